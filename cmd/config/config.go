@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sco1237896/sco-backend/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// NewConfigCmd creates the config command, commands are the commands whose
+// configuration can be dumped.
+func NewConfigCmd(commands ...func() *cobra.Command) *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration of the commands",
+	}
+
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c().Name())
+	}
+
+	dump := cobra.Command{
+		Use:   "dump <command> [flags]",
+		Short: "Write the effective configuration of a command to stdout, with secrets redacted",
+		Long: "Write the effective configuration of a command to stdout, with secrets redacted.\n\n" +
+			"The configuration is resolved as the command would, from the defaults, the --config file, " +
+			"the " + config.EnvPrefix + "* environment variables and the given flags. The output can be used as a configuration file " +
+			"once the redacted secrets are set or removed, loading a redacted secret fails.\n\n" +
+			"Commands: " + strings.Join(names, ", "),
+		Args:               cobra.MinimumNArgs(1),
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, c := range commands {
+				target := c()
+				if target.Name() != args[0] {
+					continue
+				}
+
+				if err := target.ParseFlags(args[1:]); err != nil {
+					return err
+				}
+				if target.PreRunE != nil {
+					if err := target.PreRunE(target, target.Flags().Args()); err != nil {
+						return err
+					}
+				}
+
+				out, err := config.DumpYAML(target.Flags())
+				if err != nil {
+					return err
+				}
+
+				_, err = cmd.OutOrStdout().Write(out)
+
+				return err
+			}
+
+			return fmt.Errorf("unknown command %q, must be one of %s", args[0], strings.Join(names, ", "))
+		},
+	}
+
+	cmd.AddCommand(&dump)
+
+	return &cmd
+}
@@ -4,7 +4,9 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/sco1237896/sco-backend/cmd/config"
 	"github.com/sco1237896/sco-backend/cmd/metrics"
+	"github.com/sco1237896/sco-backend/cmd/openapi"
 	"github.com/sco1237896/sco-backend/cmd/serve"
 
 	"github.com/spf13/cobra"
@@ -24,9 +26,11 @@ func main() {
 
 	rootCmd.AddCommand(serve.NewServeCmd())
 	rootCmd.AddCommand(metrics.NewMetricsCmd())
+	rootCmd.AddCommand(openapi.NewOpenAPICmd())
+	rootCmd.AddCommand(config.NewConfigCmd(serve.NewServeCmd, metrics.NewMetricsCmd))
 
 	if err := rootCmd.Execute(); err != nil {
-		logger.Error("problem running command", err)
+		logger.Error("problem running command", slog.Any("error", err))
 		os.Exit(1)
 	}
 }
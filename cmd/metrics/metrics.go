@@ -1,31 +1,35 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
-	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/stdout"
-
-	gexpvar "github.com/gin-contrib/expvar"
-	"github.com/gin-contrib/pprof"
-	sloggin "github.com/samber/slog-gin"
-
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/sco1237896/sco-backend/pkg/config"
+	"github.com/sco1237896/sco-backend/pkg/debug"
 	"github.com/sco1237896/sco-backend/pkg/logger"
 	"github.com/sco1237896/sco-backend/pkg/metrics/collector"
 	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
-	expvarsrv "github.com/sco1237896/sco-backend/pkg/metrics/publisher/expvar"
-	prometheussrv "github.com/sco1237896/sco-backend/pkg/metrics/publisher/prometheus"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/influx"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/otlp"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/remotewrite"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/statsd"
+	"github.com/sco1237896/sco-backend/pkg/metrics/rates"
+	"github.com/sco1237896/sco-backend/pkg/metrics/rules"
 	"github.com/spf13/cobra"
+
+	camelclient "github.com/apache/camel-k/v2/pkg/client"
 )
 
 const (
@@ -33,6 +37,9 @@ const (
 	writeTimeout    = 10 * time.Second
 	idleTimeout     = 120 * time.Second
 	shutdownTimeout = 5 * time.Second
+
+	// defaultTarget is the expvar endpoint of a backend running alongside.
+	defaultTarget = "http://localhost:8083/debug/vars"
 )
 
 type srv struct {
@@ -54,17 +61,29 @@ type expvar struct {
 }
 
 type collect struct {
-	from string
+	from             []string
+	fromPrometheus   []string
+	pods             collector.PodsOptions
+	discoveryTimeout time.Duration
 }
 
 type publish struct {
-	to       string
-	interval time.Duration
+	to              []string
+	interval        time.Duration
+	workers         publisher.WorkerOptions
+	shutdownTimeout time.Duration
+	rules           string
+	rates           rates.Options
+	otlp            otlp.Options
+	otlpHeaders     logger.Secret
+	statsd          statsd.Options
+	influx          influx.Options
+	mappings        string
+	remote          remotewrite.Options
 }
 
 type configs struct {
 	development bool
-	web         srv
 	expvar      expvar
 	prometheus  prometheus
 	collect     collect
@@ -74,15 +93,12 @@ type configs struct {
 var build = "develop"
 
 func NewMetricsCmd() *cobra.Command {
+	debugOpts := debug.DefaultOptions()
+	debugOpts.Addr = "0.0.0.0:9003"
+	debugOpts.ShutdownTimeout = shutdownTimeout
+
 	cfg := configs{
 		development: false,
-		web: srv{
-			host:            "0.0.0.0:9003",
-			readTimeout:     readTimeout,
-			writeTimeout:    writeTimeout,
-			idleTimeout:     idleTimeout,
-			shutdownTimeout: shutdownTimeout,
-		},
 		expvar: expvar{
 			route: "/metrics",
 			srv: srv{
@@ -104,23 +120,105 @@ func NewMetricsCmd() *cobra.Command {
 			},
 		},
 		collect: collect{
-			from: "http://localhost:8083/debug/vars",
+			discoveryTimeout: 5 * time.Second,
+			pods: collector.PodsOptions{
+				Name:   "sco-backend",
+				Port:   8083,
+				Path:   "/debug/vars",
+				Format: collector.FormatExpvar,
+			},
 		},
 		publish: publish{
-			to:       "console",
-			interval: 5 * time.Second,
+			to:              []string{publishPrometheus, publishExpvar, publishConsole},
+			interval:        5 * time.Second,
+			workers:         publisher.DefaultWorkerOptions(),
+			shutdownTimeout: shutdownTimeout,
+			rates:           rates.DefaultOptions(),
+			otlp:            otlp.DefaultOptions(),
+			statsd:          statsd.DefaultOptions(),
+			influx:          influx.DefaultOptions(),
+			remote:          remotewrite.DefaultOptions(),
 		},
 	}
 
+	var (
+		static collector.Static
+		engine *rules.Engine
+	)
+
 	cmd := &cobra.Command{
 		Use:   "metrics",
 		Short: "metrics",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := config.Load(cmd.Flags()); err != nil {
+				return err
+			}
+
 			logger.Init(cfg.development)
 			if !cfg.development {
 				gin.SetMode(gin.ReleaseMode)
 			}
-			return nil
+
+			if cfg.publish.interval <= 0 {
+				return errors.New("the publish interval must be positive")
+			}
+			if err := cfg.publish.workers.Validate(); err != nil {
+				return err
+			}
+			if err := cfg.publish.rates.Validate(); err != nil {
+				return err
+			}
+
+			if err := validatePublishers(&cfg); err != nil {
+				return err
+			}
+
+			targets, err := collector.ParseStatic(cfg.collect.from, collector.FormatExpvar)
+			if err != nil {
+				return err
+			}
+			prometheusTargets, err := collector.ParseStatic(cfg.collect.fromPrometheus, collector.FormatPrometheus)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, prometheusTargets...)
+
+			switch cfg.collect.pods.Format {
+			case collector.FormatExpvar, collector.FormatPrometheus:
+			default:
+				return fmt.Errorf("invalid pod format %q, must be %s or %s", cfg.collect.pods.Format, collector.FormatExpvar, collector.FormatPrometheus)
+			}
+
+			if cfg.collect.discoveryTimeout <= 0 {
+				return errors.New("the discovery timeout must be positive")
+			}
+
+			if cfg.publish.shutdownTimeout <= 0 {
+				return errors.New("the publish shutdown timeout must be positive")
+			}
+
+			// without any target, the backend running alongside is collected
+			if len(targets) == 0 && cfg.collect.pods.Selector == "" {
+				targets = collector.Static{{Name: "static", URL: defaultTarget, Format: collector.FormatExpvar}}
+			}
+			static = targets
+
+			ruleSet := rules.Default()
+			if cfg.publish.rules != "" {
+				loaded, err := rules.Load(cfg.publish.rules)
+				if err != nil {
+					return err
+				}
+				ruleSet = loaded
+			}
+
+			compiled, err := rules.New(ruleSet)
+			if err != nil {
+				return fmt.Errorf("invalid rules: %w", err)
+			}
+			engine = compiled
+
+			return debugOpts.Validate()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			g, ctx := errgroup.WithContext(cmd.Context())
@@ -139,77 +237,75 @@ func NewMetricsCmd() *cobra.Command {
 			log.InfoContext(ctx, "starting service", "version", build)
 			defer log.InfoContext(ctx, "shutdown complete")
 
-			log.InfoContext(ctx, "startup", "config", cfg)
+			log.InfoContext(ctx, "startup", "config", config.Dump(cmd.Flags()))
 
 			// -------------------------------------------------------------------------
 			// Start Debug Service
 
-			router := gin.Default()
-			router.Use(gin.Recovery())
-			router.Use(sloggin.New(logger.L.WithGroup("debug")))
-
-			// register pprof middleware endpoints
-			pprof.Register(router)
-
-			// register expvar endpoints
-			router.GET("/debug/vars", gexpvar.Handler())
-
-			g.Go(func() error {
-				logger.L.InfoContext(ctx, "startup", "status", "debug v1 router started", "host", cfg.web.host)
+			// the debug endpoints always have their own listener in this command,
+			// so enabled and separate behave the same
+			if debugOpts.Mode != debug.ModeDisabled {
+				dbg := debug.NewServer(debugOpts, logger.L)
+				defer func() {
+					if err := dbg.Stop(context.Background()); err != nil {
+						log.ErrorContext(ctx, "debug", "status", "could not stop debug server", "msg", err)
+					}
+				}()
+
+				g.Go(func() error {
+					logger.L.InfoContext(ctx, "startup", "status", "debug v1 router started", "host", debugOpts.Addr)
+					return dbg.Start(ctx)
+				})
+			}
 
-				srv := http.Server{
-					Addr:         cfg.web.host,
-					Handler:      router,
-					ReadTimeout:  cfg.web.readTimeout,
-					IdleTimeout:  cfg.web.idleTimeout,
-					WriteTimeout: cfg.web.writeTimeout,
-				}
+			// -------------------------------------------------------------------------
+			// Start collectors and publishers
 
-				err = srv.ListenAndServe()
+			discoverers := []collector.Discoverer{static}
+			if cfg.collect.pods.Selector != "" {
+				cl, err := camelclient.NewClient(false)
 				if err != nil {
-					return err
+					return fmt.Errorf("failed to create k8s client: %w", err)
+				}
+				if cfg.collect.pods.Namespace == "" {
+					if cfg.collect.pods.Namespace, err = cl.GetCurrentNamespace(""); err != nil {
+						return fmt.Errorf("finding the namespace of the pods to collect from: %w", err)
+					}
 				}
 
-				return nil
-			})
-
-			// -------------------------------------------------------------------------
-			// Start Prometheus Service
-
-			prom := prometheussrv.New(log, cfg.prometheus.host, cfg.prometheus.route, cfg.prometheus.readTimeout, cfg.prometheus.writeTimeout, cfg.prometheus.idleTimeout)
-			defer prom.Stop(cfg.prometheus.shutdownTimeout)
-
-			g.Go(func() error {
-				log.InfoContext(ctx, "prometheus", "status", "API listening", "host", cfg.prometheus.host)
-				return prom.Server.ListenAndServe()
-			})
-
-			// -------------------------------------------------------------------------
-			// Start expvar Service
-
-			exp := expvarsrv.New(log, cfg.expvar.host, cfg.expvar.route, cfg.expvar.readTimeout, cfg.expvar.writeTimeout, cfg.expvar.idleTimeout)
-			defer exp.Stop(cfg.expvar.shutdownTimeout)
-
-			g.Go(func() error {
-				log.InfoContext(ctx, "expvar", "status", "API listening", "host", cfg.expvar.host)
-				return exp.Server.ListenAndServe()
-			})
-
-			// -------------------------------------------------------------------------
-			// Start collectors and publishers
+				discoverers = append(discoverers, collector.NewPods(cl, cfg.collect.pods))
+			}
 
-			collector, err := collector.New(cfg.collect.from)
+			collector, err := collector.NewTargets(log, cfg.collect.discoveryTimeout, discoverers...)
 			if err != nil {
 				return fmt.Errorf("starting collector: %w", err)
 			}
 
-			pstdout := stdout.NewStdout(log)
+			publishers, stopPublishers, err := startPublishers(ctx, g, &cfg, log)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				// the publishers flush within their own shutdown timeout, once
+				// the queued data is published
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.publish.shutdownTimeout)
+				defer cancel()
+
+				stopPublishers(ctx)
+			}()
 
-			publish, err := publisher.New(log, collector, cfg.publish.interval, prom.Publish, exp.Publish, pstdout.Publish)
+			publish, err := publisher.New(log, rates.NewCollector(rules.NewCollector(collector, engine), cfg.publish.rates), cfg.publish.interval, cfg.publish.workers, publishers...)
 			if err != nil {
 				return fmt.Errorf("starting publisher: %w", err)
 			}
-			defer publish.Stop()
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.publish.shutdownTimeout)
+				defer cancel()
+
+				if err := publish.Stop(ctx); err != nil {
+					log.ErrorContext(ctx, "publish", "status", "could not publish the queued data", "msg", err)
+				}
+			}()
 
 			// -------------------------------------------------------------------------
 			// Shutdown
@@ -223,17 +319,97 @@ func NewMetricsCmd() *cobra.Command {
 
 				return nil
 			case <-ctx.Done():
-				log.ErrorContext(ctx, "metrics", "could not start http server", "msg", err)
+				log.ErrorContext(ctx, "metrics", "status", "could not start http server", "msg", err)
 				return ctx.Err()
 			}
 		},
 	}
 
 	cmd.Flags().BoolVar(&cfg.development, "dev", cfg.development, "Turn on/off development mode")
-	cmd.Flags().StringVar(&cfg.web.host, "debug-bind-address", cfg.web.host, "Main service debug address")
+	cmd.Flags().StringVar(&debugOpts.Addr, "debug-bind-address", debugOpts.Addr, "Main service debug address")
+	cmd.Flags().StringVar(&debugOpts.Mode, "debug-endpoints", debugOpts.Mode, "Serve the pprof and expvar endpoints (enabled or separate) or not (disabled)")
+	cmd.Flags().Var(&debugOpts.Token, "debug-token", "Bearer token required to access the debug endpoints")
+	cmd.Flags().StringVar(&debugOpts.TLSCertFile, "debug-tls-cert", debugOpts.TLSCertFile, "TLS certificate of the debug listener")
+	cmd.Flags().StringVar(&debugOpts.TLSKeyFile, "debug-tls-key", debugOpts.TLSKeyFile, "TLS key of the debug listener")
+	cmd.Flags().StringVar(&debugOpts.ClientCAFile, "debug-client-ca", debugOpts.ClientCAFile, "CA used to verify client certificates on the debug listener")
 	cmd.Flags().StringVar(&cfg.expvar.host, "expvar-bind-address", cfg.expvar.host, "Expvar service bind address")
 	cmd.Flags().StringVar(&cfg.prometheus.host, "prometheus-bind-address", cfg.prometheus.host, "Prometheus service bind address")
-	cmd.Flags().StringVar(&cfg.collect.from, "collect", cfg.collect.from, "Main service address used to collect metrics from")
+	cmd.Flags().StringSliceVar(&cfg.collect.from, "collect", cfg.collect.from, "Expvar endpoints to collect metrics from, as [target=]url, "+defaultTarget+" if no target nor pod selector is given")
+	cmd.Flags().StringSliceVar(&cfg.collect.fromPrometheus, "collect-prometheus", cfg.collect.fromPrometheus, "Prometheus or OpenMetrics endpoints to collect metrics from, as [target=]url")
+	cmd.Flags().StringVar(&cfg.collect.pods.Selector, "collect-pod-selector", cfg.collect.pods.Selector, "Label selector of the pods to collect metrics from, in addition to --collect")
+	cmd.Flags().DurationVar(&cfg.collect.discoveryTimeout, "collect-discovery-timeout", cfg.collect.discoveryTimeout, "How long the discovery of the pods to collect metrics from may take")
+	cmd.Flags().StringVar(&cfg.collect.pods.Namespace, "collect-pod-namespace", cfg.collect.pods.Namespace, "Namespace of the pods to collect metrics from, defaults to the current namespace")
+	cmd.Flags().IntVar(&cfg.collect.pods.Port, "collect-pod-port", cfg.collect.pods.Port, "Port of the expvar endpoint of the pods")
+	cmd.Flags().StringVar(&cfg.collect.pods.Path, "collect-pod-path", cfg.collect.pods.Path, "Path of the expvar endpoint of the pods")
+	cmd.Flags().StringVar((*string)(&cfg.collect.pods.Format), "collect-pod-format", string(cfg.collect.pods.Format), "Format of the metrics served by the pods, expvar or prometheus")
+	cmd.Flags().StringVar(&cfg.collect.pods.Name, "collect-pod-target", cfg.collect.pods.Name, "Value of the target label of the metrics collected from pods")
+	cmd.Flags().StringSliceVar(&cfg.publish.to, "publish", cfg.publish.to, "Publishers of the metrics: prometheus and expvar serve them, console logs them, and otlp, statsd, influx and remote-write push them")
+	cmd.Flags().StringVar(&cfg.publish.otlp.Protocol, "otlp-protocol", cfg.publish.otlp.Protocol, "OTLP protocol, grpc or http")
+	cmd.Flags().StringVar(&cfg.publish.otlp.Endpoint, "otlp-endpoint", cfg.publish.otlp.Endpoint, "OTLP collector host and port, defaults to the standard port of the protocol on localhost")
+	cmd.Flags().BoolVar(&cfg.publish.otlp.Insecure, "otlp-insecure", cfg.publish.otlp.Insecure, "Disable TLS for the OTLP exporter")
+	cmd.Flags().Var(&cfg.publish.otlpHeaders, "otlp-headers", "Headers sent with OTLP exports, as key=value pairs separated by commas")
+	cmd.Flags().StringVar(&cfg.publish.otlp.Compression, "otlp-compression", cfg.publish.otlp.Compression, "Compression of OTLP exports, gzip or none")
+	cmd.Flags().DurationVar(&cfg.publish.otlp.Timeout, "otlp-timeout", cfg.publish.otlp.Timeout, "Timeout of OTLP exports")
+	cmd.Flags().BoolVar(&cfg.publish.otlp.Retry.Enabled, "otlp-retry", cfg.publish.otlp.Retry.Enabled, "Retry failed OTLP exports with an exponential backoff")
+	cmd.Flags().DurationVar(&cfg.publish.otlp.Retry.InitialInterval, "otlp-retry-initial-interval", cfg.publish.otlp.Retry.InitialInterval, "Wait before the first retry of a failed OTLP export")
+	cmd.Flags().DurationVar(&cfg.publish.otlp.Retry.MaxInterval, "otlp-retry-max-interval", cfg.publish.otlp.Retry.MaxInterval, "Maximum wait between retries of a failed OTLP export")
+	cmd.Flags().DurationVar(&cfg.publish.otlp.Retry.MaxElapsedTime, "otlp-retry-max-elapsed-time", cfg.publish.otlp.Retry.MaxElapsedTime, "Maximum time spent retrying a failed OTLP export, at most --otlp-timeout which bounds the export and its retries")
+	cmd.Flags().StringToStringVar(&cfg.publish.otlp.ResourceAttributes, "otlp-resource-attributes", cfg.publish.otlp.ResourceAttributes, "Attributes added to the OTLP resource, as key=value pairs")
+	cmd.Flags().StringVar(&cfg.publish.statsd.Address, "statsd-address", cfg.publish.statsd.Address, "StatsD agent host and port, or unix:// followed by the path of its datagram socket")
+	cmd.Flags().StringVar(&cfg.publish.statsd.Flavor, "statsd-flavor", cfg.publish.statsd.Flavor, "StatsD format, statsd or dogstatsd, which sends labels as tags")
+	cmd.Flags().StringVar(&cfg.publish.statsd.Prefix, "statsd-prefix", cfg.publish.statsd.Prefix, "Prefix of the StatsD metric names")
+	cmd.Flags().DurationVar(&cfg.publish.statsd.Timeout, "statsd-timeout", cfg.publish.statsd.Timeout, "Timeout of the connection to the StatsD agent and of the writes of a publish")
+	cmd.Flags().IntVar(&cfg.publish.statsd.MaxPacketSize, "statsd-max-packet-size", cfg.publish.statsd.MaxPacketSize, "Maximum size of StatsD packets, defaults to 1432 for UDP and 8192 for Unix sockets")
+	cmd.Flags().StringVar(&cfg.publish.influx.URL, "influx-url", cfg.publish.influx.URL, "InfluxDB write endpoint, with its query, such as http://influxdb:8086/api/v2/write?org=sco&bucket=metrics")
+	cmd.Flags().Var(&cfg.publish.influx.Token, "influx-token", "InfluxDB API token")
+	cmd.Flags().StringVar(&cfg.publish.mappings, "influx-mappings", cfg.publish.mappings, "File of the rules mapping metrics to InfluxDB measurements, fields and tags")
+	cmd.Flags().DurationVar(&cfg.publish.influx.Timeout, "influx-timeout", cfg.publish.influx.Timeout, "Timeout of InfluxDB writes")
+	cmd.Flags().IntVar(&cfg.publish.influx.Queue.BatchSize, "influx-batch-size", cfg.publish.influx.Queue.BatchSize, "Maximum number of lines of an InfluxDB write")
+	cmd.Flags().IntVar(&cfg.publish.influx.Queue.MaxPending, "influx-max-pending", cfg.publish.influx.Queue.MaxPending, "Maximum number of lines kept while InfluxDB is failing, the oldest are dropped first")
+	cmd.Flags().StringVar(&cfg.publish.remote.URL, "remote-write-url", cfg.publish.remote.URL, "Prometheus remote-write endpoint, such as http://prometheus:9090/api/v1/write")
+	cmd.Flags().Var(&cfg.publish.remote.Token, "remote-write-token", "Bearer token of the remote-write endpoint")
+	cmd.Flags().DurationVar(&cfg.publish.remote.Timeout, "remote-write-timeout", cfg.publish.remote.Timeout, "Timeout of remote-write requests")
+	cmd.Flags().IntVar(&cfg.publish.remote.Queue.BatchSize, "remote-write-batch-size", cfg.publish.remote.Queue.BatchSize, "Maximum number of samples of a remote-write request")
+	cmd.Flags().IntVar(&cfg.publish.remote.Queue.MaxPending, "remote-write-max-pending", cfg.publish.remote.Queue.MaxPending, "Maximum number of samples kept while the remote-write endpoint is failing, the oldest are dropped first")
+	cmd.Flags().DurationVar(&cfg.publish.interval, "publish-interval", cfg.publish.interval, "How often metrics are collected and published")
+	cmd.Flags().IntVar(&cfg.publish.workers.QueueSize, "publish-queue-size", cfg.publish.workers.QueueSize, "Maximum number of collections waiting for a busy publisher, the oldest are dropped first")
+	cmd.Flags().DurationVar(&cfg.publish.workers.Timeout, "publish-timeout", cfg.publish.workers.Timeout, "Timeout of a publish, which takes precedence over the timeouts of the publishers when it is shorter")
+	cmd.Flags().DurationVar(&cfg.publish.shutdownTimeout, "publish-shutdown-timeout", cfg.publish.shutdownTimeout, "How long the publishers may take to publish the queued data on shutdown, the rest is dropped, and the OTLP exporter to flush")
+	cmd.Flags().StringVar(&cfg.publish.rules, "rules", cfg.publish.rules, "File of the rules filtering and reshaping the metrics before they are published, replaces the default rules, which it must repeat to keep the counters and health check labels")
+	cmd.Flags().StringSliceVar(&cfg.publish.rates.Rate, "rate", cfg.publish.rates.Rate, "Globs of the counters, once the rules apply, whose per-second rate is published with the _rate suffix")
+	cmd.Flags().StringSliceVar(&cfg.publish.rates.Delta, "delta", cfg.publish.rates.Delta, "Globs of the counters, once the rules apply, whose increase since the previous collection is published with the _delta suffix")
+	cmd.Flags().StringVar(&cfg.expvar.route, "expvar-route", cfg.expvar.route, "Route of the expvar metrics endpoint")
+	cmd.Flags().StringVar(&cfg.prometheus.route, "prometheus-route", cfg.prometheus.route, "Route of the Prometheus metrics endpoint")
+	cmd.Flags().DurationVar(&cfg.prometheus.readTimeout, "read-timeout", cfg.prometheus.readTimeout, "Maximum duration for reading an entire request to the Prometheus service")
+	cmd.Flags().DurationVar(&cfg.prometheus.writeTimeout, "write-timeout", cfg.prometheus.writeTimeout, "Maximum duration before timing out writes of a response of the Prometheus service")
+	cmd.Flags().DurationVar(&cfg.prometheus.idleTimeout, "idle-timeout", cfg.prometheus.idleTimeout, "Maximum time the Prometheus service waits for the next request on keep-alive connections")
+	cmd.Flags().DurationVar(&cfg.prometheus.shutdownTimeout, "shutdown-timeout", cfg.prometheus.shutdownTimeout, "How long the Prometheus service waits for in-flight requests on shutdown")
+	cmd.Flags().DurationVar(&cfg.expvar.readTimeout, "expvar-read-timeout", cfg.expvar.readTimeout, "Maximum duration for reading an entire request to the expvar service")
+	cmd.Flags().DurationVar(&cfg.expvar.writeTimeout, "expvar-write-timeout", cfg.expvar.writeTimeout, "Maximum duration before timing out writes of a response of the expvar service")
+	cmd.Flags().DurationVar(&cfg.expvar.idleTimeout, "expvar-idle-timeout", cfg.expvar.idleTimeout, "Maximum time the expvar service waits for the next request on keep-alive connections")
+	cmd.Flags().DurationVar(&cfg.expvar.shutdownTimeout, "expvar-shutdown-timeout", cfg.expvar.shutdownTimeout, "How long the expvar service waits for in-flight requests on shutdown")
+	cmd.Flags().DurationVar(&debugOpts.ShutdownTimeout, "debug-shutdown-timeout", debugOpts.ShutdownTimeout, "How long the separate debug server waits for in-flight requests on shutdown")
+
+	config.AddFlag(cmd.Flags())
 
 	return cmd
 }
+
+// parseHeaders parses headers given as key=value pairs separated by commas.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, errors.New("invalid OTLP header, must be key=value")
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+	expvarsrv "github.com/sco1237896/sco-backend/pkg/metrics/publisher/expvar"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/influx"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/otlp"
+	prometheussrv "github.com/sco1237896/sco-backend/pkg/metrics/publisher/prometheus"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/remotewrite"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/statsd"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/stdout"
+)
+
+const (
+	publishPrometheus = "prometheus"
+	publishExpvar     = "expvar"
+	publishConsole    = "console"
+	publishOTLP       = "otlp"
+	publishStatsD     = "statsd"
+	publishInflux     = "influx"
+	publishRemote     = "remote-write"
+)
+
+// publisherSpec declares a publisher that can be enabled with --publish.
+type publisherSpec struct {
+	// validate checks the options of the publisher, it is only called if the
+	// publisher is enabled.
+	validate func() error
+	// start creates the publisher, running its listener in g if it has one,
+	// and returns it with a function stopping it within the given context.
+	start func(ctx context.Context, g *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error)
+}
+
+// publishers declares the publishers of the metrics command, configured by cfg.
+func publishers(cfg *configs) map[string]publisherSpec {
+	noop := func() error { return nil }
+
+	return map[string]publisherSpec{
+		publishPrometheus: {
+			validate: noop,
+			start: func(ctx context.Context, g *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				prom := prometheussrv.New(log, cfg.prometheus.host, cfg.prometheus.route, cfg.prometheus.readTimeout, cfg.prometheus.writeTimeout, cfg.prometheus.idleTimeout)
+
+				g.Go(func() error {
+					log.InfoContext(ctx, "prometheus", "status", "API listening", "host", cfg.prometheus.host)
+					return prom.Server.ListenAndServe()
+				})
+
+				return prom.Publish, func(context.Context) { prom.Stop(cfg.prometheus.shutdownTimeout) }, nil
+			},
+		},
+		publishExpvar: {
+			validate: noop,
+			start: func(ctx context.Context, g *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				exp := expvarsrv.New(log, cfg.expvar.host, cfg.expvar.route, cfg.expvar.readTimeout, cfg.expvar.writeTimeout, cfg.expvar.idleTimeout)
+
+				g.Go(func() error {
+					log.InfoContext(ctx, "expvar", "status", "API listening", "host", cfg.expvar.host)
+					return exp.Server.ListenAndServe()
+				})
+
+				return exp.Publish, func(context.Context) { exp.Stop(cfg.expvar.shutdownTimeout) }, nil
+			},
+		},
+		publishConsole: {
+			validate: noop,
+			start: func(_ context.Context, _ *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				return stdout.NewStdout(log).Publish, func(context.Context) {}, nil
+			},
+		},
+		publishOTLP: {
+			validate: func() error {
+				headers, err := parseHeaders(cfg.publish.otlpHeaders.Value())
+				if err != nil {
+					return err
+				}
+				cfg.publish.otlp.Headers = headers
+
+				return cfg.publish.otlp.Validate()
+			},
+			start: func(ctx context.Context, _ *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				p, err := otlp.New(ctx, cfg.publish.otlp, build, log)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				return p.Publish, func(stopCtx context.Context) {
+					// the exporter flushes to the collector, which may be unreachable
+					if err := p.Stop(stopCtx); err != nil {
+						log.ErrorContext(ctx, "otlp", "status", "could not stop exporter", "msg", err)
+					}
+				}, nil
+			},
+		},
+		publishStatsD: {
+			validate: func() error { return cfg.publish.statsd.Validate() },
+			start: func(ctx context.Context, _ *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				p := statsd.New(cfg.publish.statsd, log)
+
+				return p.Publish, func(context.Context) {
+					if err := p.Stop(); err != nil {
+						log.ErrorContext(ctx, "statsd", "status", "could not close connection", "msg", err)
+					}
+				}, nil
+			},
+		},
+		publishInflux: {
+			validate: func() error {
+				if cfg.publish.mappings != "" {
+					mappings, err := influx.LoadMappings(cfg.publish.mappings)
+					if err != nil {
+						return err
+					}
+					cfg.publish.influx.Mappings = mappings
+				}
+
+				return cfg.publish.influx.Validate()
+			},
+			start: func(_ context.Context, _ *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				return influx.New(cfg.publish.influx, log).Publish, func(context.Context) {}, nil
+			},
+		},
+		publishRemote: {
+			validate: func() error { return cfg.publish.remote.Validate() },
+			start: func(_ context.Context, _ *errgroup.Group, log *slog.Logger) (publisher.Publisher, func(context.Context), error) {
+				return remotewrite.New(cfg.publish.remote, log).Publish, func(context.Context) {}, nil
+			},
+		},
+	}
+}
+
+// validatePublishers checks the publishers enabled in cfg and their options.
+func validatePublishers(cfg *configs) error {
+	specs := publishers(cfg)
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(cfg.publish.to) == 0 {
+		return errors.New("at least one publisher is required")
+	}
+
+	enabled := make(map[string]bool)
+	for _, to := range cfg.publish.to {
+		spec, ok := specs[to]
+		if !ok {
+			return fmt.Errorf("unknown publisher %q, must be one of %s", to, strings.Join(names, ", "))
+		}
+		if enabled[to] {
+			return fmt.Errorf("publisher %q is enabled twice", to)
+		}
+		enabled[to] = true
+
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("publisher %s: %w", to, err)
+		}
+	}
+
+	return nil
+}
+
+// startPublishers starts the publishers enabled in cfg, and returns them named
+// after their --publish name with a function stopping them in reverse order
+// within the given context.
+func startPublishers(ctx context.Context, g *errgroup.Group, cfg *configs, log *slog.Logger) ([]publisher.Named, func(context.Context), error) {
+	specs := publishers(cfg)
+
+	var pubs []publisher.Named
+	var stops []func(context.Context)
+	stop := func(ctx context.Context) {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i](ctx)
+		}
+	}
+
+	for _, to := range cfg.publish.to {
+		p, s, err := specs[to].start(ctx, g, log)
+		if err != nil {
+			stopCtx, cancel := context.WithTimeout(context.Background(), cfg.publish.shutdownTimeout)
+			stop(stopCtx)
+			cancel()
+			return nil, nil, fmt.Errorf("starting %s publisher: %w", to, err)
+		}
+
+		pubs = append(pubs, publisher.Named{Name: to, Publish: p})
+		stops = append(stops, s)
+	}
+
+	return pubs, stop, nil
+}
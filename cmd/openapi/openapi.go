@@ -0,0 +1,28 @@
+package openapi
+
+import (
+	"encoding/json"
+
+	"github.com/sco1237896/sco-backend/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+func NewOpenAPICmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "openapi",
+		Short: "Write the OpenAPI document of the API to stdout",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, err := server.OpenAPI()
+			if err != nil {
+				return err
+			}
+
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+
+			return enc.Encode(doc)
+		},
+	}
+
+	return &cmd
+}
@@ -1,20 +1,25 @@
 package serve
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/sco1237896/sco-backend/pkg/client"
+	"github.com/sco1237896/sco-backend/pkg/config"
+	"github.com/sco1237896/sco-backend/pkg/debug"
 	"github.com/sco1237896/sco-backend/pkg/health"
+	"github.com/sco1237896/sco-backend/pkg/lifecycle"
 	"github.com/sco1237896/sco-backend/pkg/logger"
 	"github.com/sco1237896/sco-backend/pkg/server"
+	"github.com/sco1237896/sco-backend/pkg/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -22,14 +27,18 @@ type Options struct {
 	Development bool
 }
 
-type ServerOptions struct {
-	Addr              string
-	ReadTimeout       time.Duration
-	WriteTimeout      time.Duration
-	IdleTimeout       time.Duration
-	ReadHeaderTimeout time.Duration
-	ShutdownTimeout   time.Duration
-}
+var build = "develop"
+
+// Names of the components run by the serve command.
+const (
+	healthComponent = "health"
+	debugComponent  = "debug"
+	serverComponent = "server"
+)
+
+// Names of the startup checks, which keep the startup probe pending until
+// they passed once.
+const pipesCheck = "pipes"
 
 func NewServeCmd() *cobra.Command {
 	opts := Options{
@@ -38,16 +47,33 @@ func NewServeCmd() *cobra.Command {
 
 	serverOpts := server.DefaultOptions()
 	healthOpts := health.DefaultOptions()
+	tracingOpts := tracing.DefaultOptions()
 
 	cmd := cobra.Command{
 		Use:   "serve",
 		Short: "serve",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := config.Load(cmd.Flags()); err != nil {
+				return err
+			}
+
 			logger.Init(opts.Development)
+			serverOpts.Development = opts.Development
 			if !opts.Development {
 				gin.SetMode(gin.ReleaseMode)
 			}
-			return nil
+
+			if err := serverOpts.Validate(); err != nil {
+				return err
+			}
+			if err := healthOpts.Validate(); err != nil {
+				return err
+			}
+			if healthOpts.Debug.Mode == debug.ModeEnabled && healthOpts.Debug.TLS() {
+				return errors.New("TLS for the debug endpoints requires --debug-endpoints=" + debug.ModeSeparate)
+			}
+
+			return tracingOpts.Validate()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
@@ -61,22 +87,71 @@ func NewServeCmd() *cobra.Command {
 
 			// -------------------------------------------------------------------------
 			// Print config to stdout
-			logger.L.Info("startup", "server config", serverOpts, "health config", healthOpts)
+			logger.L.Info("startup", "version", build, "config", config.Dump(cmd.Flags()))
+
+			// -------------------------------------------------------------------------
+			// Shut down on the first signal, interrupt the shutdown on the second
+			ctx, stop := context.WithCancel(ctx)
+			defer stop()
 
-			shutdown := make(chan os.Signal, 1)
+			stopCtx, interrupt := context.WithCancelCause(context.WithoutCancel(ctx))
+			defer interrupt(nil)
+
+			shutdown := make(chan os.Signal, 2)
 			signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(shutdown)
+
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case sig := <-shutdown:
+					logger.L.Info("Main thread is shutting down", "signal", sig)
+					stop()
+				case <-done:
+					return
+				}
+
+				select {
+				case <-shutdown:
+					logger.L.Warn("Second shutdown signal received, stopping right away")
+					interrupt(errors.New("shutdown interrupted by a second signal"))
+				case <-done:
+				}
+			}()
+
+			// -------------------------------------------------------------------------
+			// Initialize tracing
+			shutdownTracing, err := tracing.Init(ctx, tracingOpts, build)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := shutdownTracing(stopCtx); err != nil {
+					logger.L.ErrorContext(ctx, "error stopping tracing", slog.Any("error", err))
+				}
+			}()
+
+			// components are started in dependency order and stopped in reverse
+			// order, so the main server drains before the health server stops
+			m := lifecycle.New(lifecycle.DefaultOptions(), logger.L)
 
 			// -------------------------------------------------------------------------
 			// Initialize health service
 			var h *health.Service
+			var serverDeps []string
 			if healthOpts.Enabled {
 				logger.L.Info("Initializing Health Check server")
 				h = health.New(healthOpts, logger.L)
-				go func() {
-					if err := h.Start(ctx); err != nil {
-						logger.L.ErrorContext(ctx, "error in Health Check service", slog.Any("error", err))
-					}
-				}()
+				m.Add(healthComponent, h)
+				serverDeps = append(serverDeps, healthComponent)
+			}
+
+			// -------------------------------------------------------------------------
+			// Initialize debug server
+			if healthOpts.Debug.Mode == debug.ModeSeparate {
+				logger.L.Info("Initializing debug server")
+				m.Add(debugComponent, debug.NewServer(healthOpts.Debug, logger.L))
 			}
 
 			// -------------------------------------------------------------------------
@@ -87,47 +162,89 @@ func NewServeCmd() *cobra.Command {
 				return err
 			}
 
+			// -------------------------------------------------------------------------
+			// Register the startup checks before the health server starts, so the
+			// startup probe is pending until Camel K answered and the pipes were
+			// listed once
+			if h != nil {
+				h.AddStartupCheck(server.CamelKCheck, cl.Check)
+				h.AddStartupCheck(pipesCheck, func(ctx context.Context) error {
+					_, err := cl.ListPipes(ctx)
+					return err
+				})
+			}
+
 			// -------------------------------------------------------------------------
 			// Initialize backend service
 			logger.L.Info("Initializing main server")
-			s := server.New(serverOpts, cl, h, logger.L)
-			go func() {
-				if err = s.Start(ctx); err != nil {
-					logger.L.ErrorContext(ctx, "error starting main server", slog.Any("error", err))
-				}
-			}()
+			m.Add(serverComponent, server.New(serverOpts, cl, h, logger.L), serverDeps...)
 
-			logger.L.Info("Main thread running until shutdown signal")
-
-			// -------------------------------------------------------------------------
-			// Start shutdown sequence
-			sig := <-shutdown
-			logger.L.Info("Main thread is shutting down")
-			defer logger.L.Info("Main thread shutdown", "status", "shutdown complete", "signal", sig)
-
-			logger.L.Info("Terminating main server")
-			if s != nil {
-				if err := s.Stop(ctx); err != nil {
-					logger.L.ErrorContext(ctx, "error stopping the main server", slog.Any("error", err))
-				}
-			}
+			// errors from now on are not about usage
+			cmd.SilenceUsage = true
 
-			if h != nil {
-				logger.L.Info("Terminating Health Check server")
-				if err := h.Stop(ctx); err != nil {
-					logger.L.ErrorContext(ctx, "error stopping the health service", slog.Any("error", err))
-				}
-			}
+			logger.L.Info("Main thread running until shutdown signal")
+			defer logger.L.Info("Main thread shutdown", "status", "shutdown complete")
 
-			return nil
+			return m.Run(ctx, stopCtx)
 		},
 	}
 
 	cmd.Flags().StringVar(&serverOpts.Addr, "bind-address", serverOpts.Addr, "The address the server binds to.")
+	cmd.Flags().DurationVar(&serverOpts.ReadTimeout, "read-timeout", serverOpts.ReadTimeout, "Maximum duration for reading an entire request")
+	cmd.Flags().DurationVar(&serverOpts.WriteTimeout, "write-timeout", serverOpts.WriteTimeout, "Maximum duration before timing out writes of a response")
+	cmd.Flags().DurationVar(&serverOpts.IdleTimeout, "idle-timeout", serverOpts.IdleTimeout, "Maximum time to wait for the next request on keep-alive connections")
+	cmd.Flags().DurationVar(&serverOpts.ReadHeaderTimeout, "read-header-timeout", serverOpts.ReadHeaderTimeout, "Maximum duration for reading request headers")
+	cmd.Flags().DurationVar(&serverOpts.PreStopDelay, "pre-stop-delay", serverOpts.PreStopDelay, "How long the server keeps serving after readiness started failing on shutdown")
+	cmd.Flags().DurationVar(&serverOpts.ShutdownTimeout, "shutdown-timeout", serverOpts.ShutdownTimeout, "How long in-flight requests are waited for on shutdown")
 	cmd.Flags().BoolVar(&opts.Development, "dev", opts.Development, "Turn on/off development mode")
 	cmd.Flags().BoolVar(&healthOpts.Enabled, "health-check-enabled", healthOpts.Enabled, "health-check-enabled")
 	cmd.Flags().StringVar(&healthOpts.Prefix, "health-check-prefix", healthOpts.Prefix, "health-check-prefix")
 	cmd.Flags().StringVar(&healthOpts.Addr, "health-check-address", healthOpts.Addr, "health-check-address")
+	cmd.Flags().DurationVar(&healthOpts.CheckTimeout, "health-check-timeout", healthOpts.CheckTimeout, "Timeout of a single health check")
+	cmd.Flags().DurationVar(&healthOpts.CheckInterval, "health-check-interval", healthOpts.CheckInterval, "How often health checks run in the background, probes then serve their last result, 0 runs them on probes")
+	cmd.Flags().IntVar(&healthOpts.HistorySize, "health-check-history-size", healthOpts.HistorySize, "Number of results kept per health check and served at /health/history")
+	cmd.Flags().StringVar(&healthOpts.WebhookURL, "health-check-webhook-url", healthOpts.WebhookURL, "URL notified with a POST when a health check changes state")
+	cmd.Flags().DurationVar(&healthOpts.ShutdownTimeout, "health-check-shutdown-timeout", healthOpts.ShutdownTimeout, "How long the health server waits for in-flight probes on shutdown")
+	cmd.Flags().DurationVar(&healthOpts.WebhookTimeout, "health-check-webhook-timeout", healthOpts.WebhookTimeout, "Timeout of the health check webhook notifications")
+	cmd.Flags().DurationVar(&healthOpts.Debug.ShutdownTimeout, "debug-shutdown-timeout", healthOpts.Debug.ShutdownTimeout, "How long the separate debug server waits for in-flight requests on shutdown")
+	cmd.Flags().DurationVar(&healthOpts.CacheTTL, "health-check-cache-ttl", healthOpts.CacheTTL, "How long the result of a health check run by a probe is reused by later probes")
+	cmd.Flags().StringVar(&serverOpts.Checks.CRDs.Name, "check-crds-name", serverOpts.Checks.CRDs.Name, "Name of the readiness check of the Camel K custom resources")
+	cmd.Flags().StringVar((*string)(&serverOpts.Checks.CRDs.Severity), "check-crds-severity", string(serverOpts.Checks.CRDs.Severity), "Severity of the readiness check of the Camel K custom resources, one of critical, warning or disabled")
+	cmd.Flags().StringVar(&serverOpts.Checks.APILatency.Name, "check-api-latency-name", serverOpts.Checks.APILatency.Name, "Name of the readiness check of the API server latency")
+	cmd.Flags().StringVar((*string)(&serverOpts.Checks.APILatency.Severity), "check-api-latency-severity", string(serverOpts.Checks.APILatency.Severity), "Severity of the readiness check of the API server latency, one of critical, warning or disabled")
+	cmd.Flags().DurationVar(&serverOpts.Checks.LatencyThreshold, "check-api-latency-threshold", serverOpts.Checks.LatencyThreshold, "API server latency above which the latency check fails")
+	cmd.Flags().StringVar(&serverOpts.Checks.RBAC.Name, "check-rbac-name", serverOpts.Checks.RBAC.Name, "Name of the readiness check of the RBAC permissions")
+	cmd.Flags().StringVar((*string)(&serverOpts.Checks.RBAC.Severity), "check-rbac-severity", string(serverOpts.Checks.RBAC.Severity), "Severity of the readiness check of the RBAC permissions, one of critical, warning or disabled")
+	cmd.Flags().StringVar(&serverOpts.Checks.Namespace, "check-rbac-namespace", serverOpts.Checks.Namespace, "Namespace the RBAC permissions are checked in, all namespaces if empty")
+	cmd.Flags().StringVar(&serverOpts.Checks.Operator.Name, "check-operator-name", serverOpts.Checks.Operator.Name, "Name of the readiness check of the Camel K operator")
+	cmd.Flags().StringVar((*string)(&serverOpts.Checks.Operator.Severity), "check-operator-severity", string(serverOpts.Checks.Operator.Severity), "Severity of the readiness check of the Camel K operator, one of critical, warning or disabled")
+	cmd.Flags().StringVar(&serverOpts.Checks.OperatorNamespace, "camel-k-operator-namespace", serverOpts.Checks.OperatorNamespace, "Namespace of the Camel K operator deployment, all namespaces if empty")
+	cmd.Flags().StringVar(&serverOpts.Checks.OperatorName, "camel-k-operator-deployment", serverOpts.Checks.OperatorName, "Name of the Camel K operator deployment, looked up by label if empty")
+	cmd.Flags().Var(&serverOpts.Maintenance.AdminToken, "admin-token", "Bearer token of the admin endpoints, which are not served without one")
+	cmd.Flags().BoolVar(&serverOpts.Maintenance.Signal, "maintenance-signal", serverOpts.Maintenance.Signal, "Toggle maintenance mode on SIGUSR1")
+	cmd.Flags().StringVar(&serverOpts.Maintenance.File, "maintenance-file", serverOpts.Maintenance.File, "Enable maintenance mode while this file exists, using its content as banner")
+	cmd.Flags().DurationVar(&serverOpts.Maintenance.FileInterval, "maintenance-file-interval", serverOpts.Maintenance.FileInterval, "How often the maintenance file is checked")
+	cmd.Flags().BoolVar(&serverOpts.Maintenance.Degraded, "maintenance-degraded", serverOpts.Maintenance.Degraded, "Report readiness as degraded during maintenance")
+	cmd.Flags().StringVar(&serverOpts.Maintenance.Message, "maintenance-message", serverOpts.Maintenance.Message, "Default banner shown during maintenance")
+	cmd.Flags().StringVar(&healthOpts.Debug.Mode, "debug-endpoints", healthOpts.Debug.Mode, "Serve the pprof and expvar endpoints on the health check port (enabled), on their own port (separate) or not at all (disabled)")
+	cmd.Flags().StringVar(&healthOpts.Debug.Addr, "debug-address", healthOpts.Debug.Addr, "The address the debug endpoints bind to with --debug-endpoints=separate")
+	cmd.Flags().Var(&healthOpts.Debug.Token, "debug-token", "Bearer token required to access the debug endpoints")
+	cmd.Flags().StringVar(&healthOpts.Debug.TLSCertFile, "debug-tls-cert", healthOpts.Debug.TLSCertFile, "TLS certificate of the separate debug listener")
+	cmd.Flags().StringVar(&healthOpts.Debug.TLSKeyFile, "debug-tls-key", healthOpts.Debug.TLSKeyFile, "TLS key of the separate debug listener")
+	cmd.Flags().StringVar(&healthOpts.Debug.ClientCAFile, "debug-client-ca", healthOpts.Debug.ClientCAFile, "CA used to verify client certificates on the separate debug listener")
+	cmd.Flags().Float64Var(&serverOpts.RateLimit.ReadRate, "rate-limit-read", serverOpts.RateLimit.ReadRate, "Read requests per second allowed for each client, 0 disables the limit")
+	cmd.Flags().IntVar(&serverOpts.RateLimit.ReadBurst, "rate-limit-read-burst", serverOpts.RateLimit.ReadBurst, "Burst of read requests allowed for each client")
+	cmd.Flags().Float64Var(&serverOpts.RateLimit.WriteRate, "rate-limit-write", serverOpts.RateLimit.WriteRate, "Write requests per second allowed for each client, 0 disables the limit")
+	cmd.Flags().IntVar(&serverOpts.RateLimit.WriteBurst, "rate-limit-write-burst", serverOpts.RateLimit.WriteBurst, "Burst of write requests allowed for each client")
+	cmd.Flags().IntVar(&serverOpts.RateLimit.MaxInFlight, "max-in-flight", serverOpts.RateLimit.MaxInFlight, "Maximum number of requests served concurrently, 0 disables the cap")
+	cmd.Flags().StringVar(&serverOpts.RateLimit.PrincipalHeader, "rate-limit-principal-header", serverOpts.RateLimit.PrincipalHeader, "Header identifying the client by principal for rate limiting, such as X-Forwarded-User, only for deployments behind a trusted proxy setting it")
+	cmd.Flags().StringVar(&serverOpts.RateLimit.APIKeyHeader, "rate-limit-api-key-header", serverOpts.RateLimit.APIKeyHeader, "Header identifying the client by API key for rate limiting, such as X-API-Key, only for deployments behind a trusted proxy checking it")
+	cmd.Flags().StringVar(&tracingOpts.Exporter, "tracing-exporter", tracingOpts.Exporter, "Tracing exporter, one of none, otlp-grpc, otlp-http or stdout")
+	cmd.Flags().StringVar(&tracingOpts.Endpoint, "tracing-endpoint", tracingOpts.Endpoint, "Tracing collector endpoint, defaults to the OTEL_EXPORTER_OTLP_* environment variables")
+	cmd.Flags().BoolVar(&tracingOpts.Insecure, "tracing-insecure", tracingOpts.Insecure, "Disable TLS for the tracing collector connection")
+	cmd.Flags().Float64Var(&tracingOpts.SampleRatio, "tracing-sample-ratio", tracingOpts.SampleRatio, "Ratio of new traces that are sampled, between 0 and 1")
+
+	config.AddFlag(cmd.Flags())
 
 	return &cmd
 }
@@ -108,3 +108,58 @@ func TestMainHealthCheck(t *testing.T) {
 	}
 	g.Consistently(cleanhttp.DefaultClient().Do).WithArguments(req).Within(1 * time.Second).Should(HaveHTTPStatus(http.StatusServiceUnavailable))
 }
+
+func TestGracefulShutdown(t *testing.T) {
+	g := NewWithT(t)
+
+	c, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cmd := serve.NewServeCmd()
+	cmd.SetArgs([]string{
+		"--health-check-enabled",
+		"--bind-address", "localhost:9190",
+		"--health-check-address", "localhost:9191",
+		"--pre-stop-delay", "3s",
+		"--shutdown-timeout", "2s",
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.ExecuteContext(c)
+	}()
+
+	get := func(url string) func() (*http.Response, error) {
+		return func() (*http.Response, error) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, http.NoBody)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := cleanhttp.DefaultClient().Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			return resp, nil
+		}
+	}
+
+	ready := get("http://localhost:9191/health/ready")
+	pipes := get("http://localhost:9190/v1/pipes/")
+
+	g.Eventually(ready).WithTimeout(10 * time.Second).Should(HaveHTTPStatus(http.StatusOK))
+	g.Eventually(pipes).Should(HaveHTTPStatus(http.StatusOK))
+
+	// start the shutdown sequence
+	cancel()
+
+	// readiness fails first, while requests are still served during the pre-stop delay
+	g.Eventually(ready).WithTimeout(time.Second).Should(HaveHTTPStatus(http.StatusServiceUnavailable))
+	g.Consistently(pipes).WithTimeout(2 * time.Second).Should(HaveHTTPStatus(http.StatusOK))
+
+	// then the server stops accepting connections and the command returns
+	g.Eventually(done).WithTimeout(10 * time.Second).Should(Receive(BeNil()))
+
+	_, err := pipes()
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Severity tells how a failing check affects the probe it is registered with.
+type Severity string
+
+const (
+	// SeverityCritical checks fail the probe.
+	SeverityCritical Severity = "critical"
+	// SeverityWarning checks report a degraded dependency without failing the probe.
+	SeverityWarning Severity = "warning"
+	// SeverityDisabled checks are not registered at all.
+	SeverityDisabled Severity = "disabled"
+)
+
+// NamedCheck is a check together with the name and severity it is registered with.
+type NamedCheck struct {
+	Name     string
+	Severity Severity
+	Check    func(c context.Context) error
+}
+
+type CheckOptions struct {
+	Name     string
+	Severity Severity
+}
+
+type ChecksOptions struct {
+	// CRDs checks that the custom resources served by the backend are installed
+	// at one of the supported versions.
+	CRDs CheckOptions
+	// APILatency checks that the API server answers within LatencyThreshold.
+	APILatency       CheckOptions
+	LatencyThreshold time.Duration
+	// RBAC checks that the backend is allowed to read pipes in Namespace, all
+	// namespaces if empty.
+	RBAC      CheckOptions
+	Namespace string
+	// Operator checks that the Camel K operator deployment is available. With
+	// an empty OperatorName, the deployment is looked up by its labels.
+	Operator          CheckOptions
+	OperatorNamespace string
+	OperatorName      string
+}
+
+func DefaultChecksOptions() ChecksOptions {
+	return ChecksOptions{
+		CRDs:             CheckOptions{Name: "camel-k-crds", Severity: SeverityCritical},
+		APILatency:       CheckOptions{Name: "kubernetes-api-latency", Severity: SeverityWarning},
+		LatencyThreshold: 500 * time.Millisecond,
+		RBAC:             CheckOptions{Name: "kubernetes-rbac", Severity: SeverityCritical},
+		Operator:         CheckOptions{Name: "camel-k-operator", Severity: SeverityWarning},
+	}
+}
+
+func (o ChecksOptions) Validate() error {
+	names := make(map[string]bool)
+	for _, co := range []CheckOptions{o.CRDs, o.APILatency, o.RBAC, o.Operator} {
+		switch co.Severity {
+		case SeverityCritical, SeverityWarning, SeverityDisabled:
+		default:
+			return fmt.Errorf("invalid severity %q for check %s, must be one of %s, %s or %s", co.Severity, co.Name, SeverityCritical, SeverityWarning, SeverityDisabled)
+		}
+		if co.Severity == SeverityDisabled {
+			continue
+		}
+		if co.Name == "" {
+			return errors.New("health checks must have a name")
+		}
+		if names[co.Name] {
+			return fmt.Errorf("health check %s is registered twice", co.Name)
+		}
+		names[co.Name] = true
+	}
+
+	return nil
+}
+
+// SupportedCRDs are the custom resources, at their supported versions, the
+// backend relies on.
+var SupportedCRDs = []schema.GroupVersionKind{
+	{Group: "camel.apache.org", Version: "v1", Kind: "Pipe"},
+	{Group: "camel.apache.org", Version: "v1", Kind: "Kamelet"},
+	{Group: "camel.apache.org", Version: "v1", Kind: "Integration"},
+}
+
+// PipeAccess are the permissions the backend needs on pipes, which it only
+// reads.
+var PipeAccess = []authv1.ResourceAttributes{
+	{Group: "camel.apache.org", Resource: "pipes", Verb: "get"},
+	{Group: "camel.apache.org", Resource: "pipes", Verb: "list"},
+	{Group: "camel.apache.org", Resource: "pipes", Verb: "watch"},
+}
+
+// operatorSelector matches the deployment of the Camel K operator.
+const operatorSelector = "app=camel-k,camel.apache.org/component=operator"
+
+// CRDsCheck verifies that every kind is served at its version.
+func CRDsCheck(cl kubernetes.Interface, kinds ...schema.GroupVersionKind) func(c context.Context) error {
+	return func(c context.Context) error {
+		served := make(map[schema.GroupVersion]map[string]bool)
+
+		var missing []string
+		for _, gvk := range kinds {
+			gv := gvk.GroupVersion()
+
+			if _, ok := served[gv]; !ok {
+				served[gv] = make(map[string]bool)
+
+				resources, err := cl.Discovery().ServerResourcesForGroupVersion(gv.String())
+				if err != nil && !apierrors.IsNotFound(err) {
+					return errors.Wrapf(err, "failed to discover %s", gv)
+				}
+				if resources != nil {
+					for _, r := range resources.APIResources {
+						served[gv][r.Kind] = true
+					}
+				}
+			}
+
+			if !served[gv][gvk.Kind] {
+				missing = append(missing, gvk.Kind+"."+gv.String())
+			}
+		}
+
+		if len(missing) > 0 {
+			return fmt.Errorf("custom resources not installed: %s", strings.Join(missing, ", "))
+		}
+
+		return nil
+	}
+}
+
+// APILatencyCheck verifies that the API server answers a version request
+// within threshold.
+func APILatencyCheck(cl kubernetes.Interface, threshold time.Duration) func(c context.Context) error {
+	return func(c context.Context) error {
+		start := time.Now()
+
+		if err := cl.Discovery().RESTClient().Get().AbsPath("/version").Do(c).Error(); err != nil {
+			return errors.Wrap(err, "failed to reach the API server")
+		}
+
+		if latency := time.Since(start); latency > threshold {
+			return fmt.Errorf("API server latency %s above threshold %s", latency, threshold)
+		}
+
+		return nil
+	}
+}
+
+// RBACCheck verifies, with a SelfSubjectAccessReview for each of them, that
+// the backend is granted the given permissions in namespace.
+func RBACCheck(cl kubernetes.Interface, namespace string, access ...authv1.ResourceAttributes) func(c context.Context) error {
+	return func(c context.Context) error {
+		var denied []string
+		for _, a := range access {
+			a.Namespace = namespace
+
+			review := &authv1.SelfSubjectAccessReview{
+				Spec: authv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &a},
+			}
+
+			review, err := cl.AuthorizationV1().SelfSubjectAccessReviews().Create(c, review, metav1.CreateOptions{})
+			if err != nil {
+				return errors.Wrap(err, "failed to review access")
+			}
+			if !review.Status.Allowed {
+				denied = append(denied, a.Verb+" "+a.Resource+"."+a.Group)
+			}
+		}
+
+		if len(denied) > 0 {
+			return fmt.Errorf("permissions denied: %s", strings.Join(denied, ", "))
+		}
+
+		return nil
+	}
+}
+
+// OperatorCheck verifies that the Camel K operator deployment is available.
+// If name is empty, the deployment is looked up by label in namespace, or in
+// all namespaces if that is empty too.
+func OperatorCheck(cl kubernetes.Interface, namespace string, name string) func(c context.Context) error {
+	return func(c context.Context) error {
+		var deployments []appsv1.Deployment
+
+		if name != "" {
+			d, err := cl.AppsV1().Deployments(namespace).Get(c, name, metav1.GetOptions{})
+			if err != nil {
+				return errors.Wrap(err, "failed to find the Camel K operator")
+			}
+			deployments = append(deployments, *d)
+		} else {
+			list, err := cl.AppsV1().Deployments(namespace).List(c, metav1.ListOptions{LabelSelector: operatorSelector})
+			if err != nil {
+				return errors.Wrap(err, "failed to find the Camel K operator")
+			}
+			deployments = list.Items
+		}
+
+		if len(deployments) == 0 {
+			return errors.New("failed to find the Camel K operator. Is Camel K installed?")
+		}
+
+		for _, d := range deployments {
+			for _, cond := range d.Status.Conditions {
+				if cond.Type == appsv1.DeploymentAvailable && cond.Status == corev1.ConditionTrue {
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("the Camel K operator deployment %s/%s is not available", deployments[0].Namespace, deployments[0].Name)
+	}
+}
+
+// checks builds the checks enabled in opts against cl.
+func checks(cl kubernetes.Interface, opts ChecksOptions) []NamedCheck {
+	all := []NamedCheck{
+		{Name: opts.CRDs.Name, Severity: opts.CRDs.Severity, Check: CRDsCheck(cl, SupportedCRDs...)},
+		{Name: opts.APILatency.Name, Severity: opts.APILatency.Severity, Check: APILatencyCheck(cl, opts.LatencyThreshold)},
+		{Name: opts.RBAC.Name, Severity: opts.RBAC.Severity, Check: RBACCheck(cl, opts.Namespace, PipeAccess...)},
+		{Name: opts.Operator.Name, Severity: opts.Operator.Severity, Check: OperatorCheck(cl, opts.OperatorNamespace, opts.OperatorName)},
+	}
+
+	enabled := make([]NamedCheck, 0, len(all))
+	for _, c := range all {
+		if c.Severity != SeverityDisabled {
+			enabled = append(enabled, c)
+		}
+	}
+
+	return enabled
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCRDsCheck(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	cl.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "camel.apache.org/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pipes", Kind: "Pipe"},
+			{Name: "integrations", Kind: "Integration"},
+		},
+	}}
+
+	err := CRDsCheck(cl, SupportedCRDs...)(context.Background())
+	assert.EqualError(t, err, "custom resources not installed: Kamelet.camel.apache.org/v1")
+
+	cl.Resources[0].APIResources = append(cl.Resources[0].APIResources, metav1.APIResource{Name: "kamelets", Kind: "Kamelet"})
+	assert.NoError(t, CRDsCheck(cl, SupportedCRDs...)(context.Background()))
+}
+
+func TestRBACCheck(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	cl.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb != "watch"
+		return true, review, nil
+	})
+
+	err := RBACCheck(cl, "ns", PipeAccess...)(context.Background())
+	assert.EqualError(t, err, "permissions denied: watch pipes.camel.apache.org")
+}
+
+func TestOperatorCheck(t *testing.T) {
+	operator := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "camel-k",
+			Name:      "camel-k-operator",
+			Labels:    map[string]string{"app": "camel-k", "camel.apache.org/component": "operator"},
+		},
+	}
+
+	cl := fake.NewSimpleClientset()
+	assert.ErrorContains(t, OperatorCheck(cl, "", "")(context.Background()), "Is Camel K installed?")
+
+	cl = fake.NewSimpleClientset(operator)
+	assert.ErrorContains(t, OperatorCheck(cl, "", "")(context.Background()), "is not available")
+
+	operator.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue}}
+	cl = fake.NewSimpleClientset(operator)
+	assert.NoError(t, OperatorCheck(cl, "", "")(context.Background()))
+	assert.NoError(t, OperatorCheck(cl, "camel-k", "camel-k-operator")(context.Background()))
+}
+
+func TestChecksOptions(t *testing.T) {
+	opts := DefaultChecksOptions()
+	assert.NoError(t, opts.Validate())
+
+	opts.RBAC.Severity = SeverityDisabled
+	assert.Len(t, checks(fake.NewSimpleClientset(), opts), 3)
+
+	// a disabled check does not take its name
+	opts.Operator.Name = opts.RBAC.Name
+	assert.NoError(t, opts.Validate())
+
+	opts.Operator.Name = opts.CRDs.Name
+	assert.EqualError(t, opts.Validate(), "health check camel-k-crds is registered twice")
+	opts.Operator.Name = "camel-k-operator"
+
+	opts.CRDs.Severity = "fatal"
+	assert.Error(t, opts.Validate())
+}
@@ -13,6 +13,7 @@ import (
 type Interface interface {
 	Check(c context.Context) error
 	ListPipes(c context.Context) (*camelv1.PipeList, error)
+	Checks(opts ChecksOptions) []NamedCheck
 }
 
 func New() (Interface, error) {
@@ -23,5 +24,5 @@ func New() (Interface, error) {
 		return nil, fmt.Errorf("failed to create camel k8s client: %w", err)
 	}
 
-	return &defaultClient{camelCl: cl, logger: l}, nil
+	return newTracingClient(&defaultClient{camelCl: cl, logger: l}), nil
 }
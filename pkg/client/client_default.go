@@ -30,6 +30,10 @@ func (cl *defaultClient) Check(c context.Context) error {
 	return nil
 }
 
+func (cl *defaultClient) Checks(opts ChecksOptions) []NamedCheck {
+	return checks(cl.camelCl, opts)
+}
+
 func (cl *defaultClient) ListPipes(c context.Context) (*camelv1.PipeList, error) {
 	list := &camelv1.PipeList{}
 	err := cl.camelCl.List(c, list)
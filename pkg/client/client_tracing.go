@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+
+	camelv1 "github.com/apache/camel-k/v2/pkg/apis/camel/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sco1237896/sco-backend/pkg/tracing"
+)
+
+// tracingClient wraps an Interface and creates a client span around every call.
+type tracingClient struct {
+	next   Interface
+	tracer trace.Tracer
+}
+
+var _ Interface = &tracingClient{}
+
+func newTracingClient(next Interface) *tracingClient {
+	return &tracingClient{
+		next:   next,
+		tracer: tracing.Tracer("github.com/sco1237896/sco-backend/pkg/client"),
+	}
+}
+
+func (cl *tracingClient) start(c context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return cl.tracer.Start(c, "client."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func (cl *tracingClient) Check(c context.Context) (err error) {
+	c, span := cl.start(c, "Check")
+	defer func() { tracing.End(span, err) }()
+
+	return cl.next.Check(c)
+}
+
+// Checks is not traced here, as the health service traces every check run.
+func (cl *tracingClient) Checks(opts ChecksOptions) []NamedCheck {
+	return cl.next.Checks(opts)
+}
+
+func (cl *tracingClient) ListPipes(c context.Context) (list *camelv1.PipeList, err error) {
+	c, span := cl.start(c, "ListPipes", attribute.String("k8s.resource", "pipes"))
+	defer func() {
+		if list != nil {
+			span.SetAttributes(attribute.Int("k8s.items", len(list.Items)))
+		}
+		tracing.End(span, err)
+	}()
+
+	return cl.next.ListPipes(c)
+}
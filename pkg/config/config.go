@@ -0,0 +1,272 @@
+// Package config layers configuration files and environment variables on
+// top of command line flags.
+//
+// Every flag of a command is also a configuration key. Values are resolved,
+// from lowest to highest precedence, from the flag defaults, the YAML or JSON
+// configuration file, the SCO_* environment variables and the command line.
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// EnvPrefix is the prefix of the environment variables, the variable of
+	// a flag is its name in upper case with dashes replaced by underscores,
+	// such as SCO_BIND_ADDRESS for --bind-address.
+	EnvPrefix = "SCO_"
+
+	// FileFlag is the flag of the configuration file.
+	FileFlag = "config"
+)
+
+// AddFlag adds the flag of the configuration file to fs.
+func AddFlag(fs *pflag.FlagSet) {
+	fs.String(FileFlag, "", "Configuration file in YAML or JSON, keyed by flag name, also read from "+EnvName(FileFlag))
+}
+
+// EnvName returns the environment variable of a flag.
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Load sets the flags of fs that were not given on the command line from the
+// environment, then from the configuration file.
+func Load(fs *pflag.FlagSet) error {
+	file, err := readFile(fs)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == FileFlag {
+			return
+		}
+
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := set(f, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", EnvName(f.Name), err))
+			}
+			return
+		}
+
+		if v, ok := file[f.Name]; ok {
+			if err := set(f, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", f.Name, err))
+			}
+		}
+	})
+
+	for key := range file {
+		if fs.Lookup(key) == nil {
+			errs = append(errs, fmt.Sprintf("%s: unknown configuration key", key))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Dump returns the effective configuration of fs keyed by flag name. Secrets
+// are redacted, as flags holding a logger.Secret print redacted values.
+func Dump(fs *pflag.FlagSet) map[string]any {
+	out := make(map[string]any)
+
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name == FileFlag || f.Name == "help" {
+			return
+		}
+
+		out[f.Name] = value(fs, f)
+	})
+
+	return out
+}
+
+// DumpYAML renders the effective configuration of fs as a configuration file.
+func DumpYAML(fs *pflag.FlagSet) ([]byte, error) {
+	return yaml.Marshal(Dump(fs))
+}
+
+// readFile reads the configuration file given with --config or its
+// environment variable. Nested sections are flattened, so that
+//
+//	health-check:
+//	  timeout: 1s
+//
+// sets --health-check-timeout.
+func readFile(fs *pflag.FlagSet) (map[string]any, error) {
+	name := ""
+	if f := fs.Lookup(FileFlag); f != nil {
+		name = f.Value.String()
+	}
+	if name == "" {
+		name = os.Getenv(EnvName(FileFlag))
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	raw := make(map[string]any)
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file %s: %w", name, err)
+	}
+
+	out := make(map[string]any)
+	flatten(fs, "", raw, out)
+
+	return out, nil
+}
+
+// flatten flattens the nested sections of in, the maps of the key=value flags
+// being values rather than sections.
+func flatten(fs *pflag.FlagSet, prefix string, in map[string]any, out map[string]any) {
+	for k, v := range in {
+		if prefix != "" {
+			k = prefix + "-" + k
+		}
+
+		if m, ok := v.(map[string]any); ok && !isMap(fs.Lookup(k)) {
+			flatten(fs, k, m, out)
+			continue
+		}
+
+		out[k] = v
+	}
+}
+
+// set sets a flag from an environment variable or a decoded configuration value.
+func set(f *pflag.Flag, v any) error {
+	var items []string
+	switch t := v.(type) {
+	case map[string]any:
+		return setMap(f, t)
+	case nil:
+		// an empty list is dumped as null
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			return sv.Replace(nil)
+		}
+		items = []string{""}
+	case []any:
+		for _, i := range t {
+			items = append(items, scalar(i))
+		}
+	case string:
+		items = []string{t}
+	default:
+		items = []string{scalar(t)}
+	}
+
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		if _, isString := v.(string); isString {
+			items = strings.Split(items[0], ",")
+		}
+		return sv.Replace(items)
+	}
+
+	return f.Value.Set(strings.Join(items, ","))
+}
+
+// setMap sets a key=value flag from a map. An empty map keeps the default,
+// as the flag cannot be set to an empty map.
+func setMap(f *pflag.Flag, m map[string]any) error {
+	if !isMap(f) {
+		return errors.New("must not be a map")
+	}
+	if len(m) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+scalar(m[k]))
+	}
+
+	// the flag reads the pairs as a CSV record
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(pairs); err != nil {
+		return err
+	}
+	w.Flush()
+
+	return f.Value.Set(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// isMap returns whether f is a key=value flag, set from a map.
+func isMap(f *pflag.Flag) bool {
+	return f != nil && f.Value.Type() == "stringToString"
+}
+
+func scalar(v any) string {
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// value converts a flag value of fs to the type used in configuration files.
+// Lists and maps are never nil, so that they are not dumped as null.
+func value(fs *pflag.FlagSet, f *pflag.Flag) any {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		if items := sv.GetSlice(); items != nil {
+			return items
+		}
+		return []string{}
+	}
+
+	if isMap(f) {
+		if m, err := fs.GetStringToString(f.Name); err == nil && m != nil {
+			return m
+		}
+		return map[string]string{}
+	}
+
+	s := f.Value.String()
+	switch f.Value.Type() {
+	case "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case "float32", "float64":
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+type options struct {
+	addr    string
+	timeout time.Duration
+	enabled bool
+	ratio   float64
+	tags    []string
+	attrs   map[string]string
+	token   logger.Secret
+}
+
+func flags(opts *options) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringVar(&opts.addr, "bind-address", ":8080", "")
+	fs.DurationVar(&opts.timeout, "health-check-timeout", time.Second, "")
+	fs.BoolVar(&opts.enabled, "health-check-enabled", false, "")
+	fs.Float64Var(&opts.ratio, "sample-ratio", 1, "")
+	fs.StringSliceVar(&opts.tags, "tags", nil, "")
+	fs.StringToStringVar(&opts.attrs, "resource-attributes", nil, "")
+	fs.Var(&opts.token, "token", "")
+	AddFlag(fs)
+
+	return fs
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+bind-address: ":9000"
+sample-ratio: 0.25
+tags: [a, b]
+health-check:
+  enabled: true
+  timeout: 2s
+`), 0o600))
+
+	t.Setenv("SCO_HEALTH_CHECK_TIMEOUT", "3s")
+
+	opts := options{}
+	fs := flags(&opts)
+	assert.NoError(t, fs.Parse([]string{"--config", file, "--bind-address", ":9090"}))
+	assert.NoError(t, Load(fs))
+
+	// flags win over the environment, which wins over the file
+	assert.Equal(t, ":9090", opts.addr)
+	assert.Equal(t, 3*time.Second, opts.timeout)
+	assert.True(t, opts.enabled)
+	assert.InDelta(t, 0.25, opts.ratio, 0.0001)
+	assert.Equal(t, []string{"a", "b"}, opts.tags)
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"bind-adress": ":9000"}`), 0o600))
+
+	t.Setenv("SCO_CONFIG", file)
+
+	fs := flags(&options{})
+	assert.EqualError(t, Load(fs), "invalid configuration: bind-adress: unknown configuration key")
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	t.Setenv("SCO_TOKEN", "hunter2")
+
+	opts := options{}
+	fs := flags(&opts)
+	assert.NoError(t, Load(fs))
+	assert.Equal(t, "hunter2", opts.token.Value())
+
+	dump := Dump(fs)
+	assert.Equal(t, "REDACTED", dump["token"])
+	assert.Equal(t, false, dump["health-check-enabled"])
+	assert.Equal(t, "1s", dump["health-check-timeout"])
+	assert.NotContains(t, dump, FileFlag)
+
+	// a dump cannot be loaded back until its secrets are set
+	out, err := DumpYAML(fs)
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, out, 0o600))
+	t.Setenv("SCO_CONFIG", file)
+	t.Setenv("SCO_TOKEN", "")
+	os.Unsetenv("SCO_TOKEN")
+
+	assert.EqualError(t, Load(flags(&options{})), "invalid configuration: token: the secret is redacted, set its value")
+}
+
+func TestDumpLoadsBack(t *testing.T) {
+	t.Setenv("SCO_RESOURCE_ATTRIBUTES", "service.name=sco,team=a=b")
+
+	opts := options{}
+	fs := flags(&opts)
+	assert.NoError(t, fs.Parse([]string{"--sample-ratio", "0.5"}))
+	assert.NoError(t, Load(fs))
+
+	dump := Dump(fs)
+	assert.Equal(t, map[string]string{"service.name": "sco", "team": "a=b"}, dump["resource-attributes"])
+	assert.Equal(t, []string{}, dump["tags"])
+
+	out, err := DumpYAML(fs)
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, out, 0o600))
+	os.Unsetenv("SCO_RESOURCE_ATTRIBUTES")
+
+	// the secret is the only value to set before the dump is a configuration file
+	loaded := options{}
+	fs = flags(&loaded)
+	assert.NoError(t, fs.Parse([]string{"--config", file, "--token", "hunter2"}))
+	assert.NoError(t, Load(fs))
+
+	opts.token, loaded.token = "", ""
+	assert.Equal(t, opts, loaded)
+}
@@ -0,0 +1,223 @@
+// Package debug serves the pprof and expvar debug endpoints.
+package debug
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/expvar"
+	"github.com/gin-contrib/pprof"
+	"github.com/gin-gonic/gin"
+	sloggin "github.com/samber/slog-gin"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+const (
+	// ModeDisabled does not serve the debug endpoints at all.
+	ModeDisabled = "disabled"
+	// ModeEnabled serves the debug endpoints next to the other endpoints of a listener.
+	ModeEnabled = "enabled"
+	// ModeSeparate serves the debug endpoints on their own listener.
+	ModeSeparate = "separate"
+)
+
+type Options struct {
+	Mode string
+	Addr string
+
+	// Token, when set, is required as a bearer token on every debug request.
+	Token logger.Secret
+
+	// TLSCertFile and TLSKeyFile enable TLS on a separate listener and
+	// ClientCAFile additionally requires client certificates signed by that CA.
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+
+	ShutdownTimeout time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Mode:            ModeEnabled,
+		Addr:            ":8082",
+		ShutdownTimeout: 3 * time.Second,
+	}
+}
+
+func (o Options) Validate() error {
+	switch o.Mode {
+	case ModeDisabled, ModeEnabled, ModeSeparate:
+	default:
+		return fmt.Errorf("invalid debug endpoints mode %q, must be one of %s, %s or %s", o.Mode, ModeDisabled, ModeEnabled, ModeSeparate)
+	}
+
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return errors.New("both a TLS certificate and a TLS key are required for the debug endpoints")
+	}
+	if o.ClientCAFile != "" && o.TLSCertFile == "" {
+		return errors.New("a client CA for the debug endpoints requires TLS")
+	}
+
+	return nil
+}
+
+// TLS reports whether the options require a TLS listener.
+func (o Options) TLS() bool {
+	return o.TLSCertFile != ""
+}
+
+// Register adds the pprof and expvar endpoints to router, behind bearer token
+// authentication if a token is configured.
+func Register(router *gin.Engine, opts Options) {
+	group := router.Group("/debug")
+	if opts.Token != "" {
+		group.Use(bearer(opts.Token.Value()))
+	}
+
+	// register pprof middleware endpoints
+	pprof.RouteRegister(group, "pprof")
+
+	// register expvar endpoints
+	group.GET("/vars", expvar.Handler())
+}
+
+func bearer(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="debug"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Server serves the debug endpoints on their own listener.
+type Server struct {
+	l       *slog.Logger
+	opts    Options
+	srv     *http.Server
+	running atomic.Bool
+	// listening is set once the server accepts connections
+	listening atomic.Bool
+}
+
+func NewServer(opts Options, l *slog.Logger) *Server {
+	s := Server{
+		l:    l.WithGroup("debug"),
+		opts: opts,
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(sloggin.New(s.l))
+
+	Register(router, opts)
+
+	s.srv = &http.Server{
+		// profiles stream for as long as requested, so there is no write timeout
+		ReadHeaderTimeout: 2 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		Addr:              opts.Addr,
+		Handler:           router,
+		ErrorLog:          slog.NewLogLogger(s.l.Handler(), slog.LevelError),
+	}
+
+	return &s
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	s.l.InfoContext(ctx, "Starting debug server", slog.String("address", s.opts.Addr), slog.Bool("tls", s.opts.TLS()))
+
+	var err error
+	if s.opts.TLS() {
+		s.srv.TLSConfig, err = tlsConfig(s.opts)
+	}
+
+	var ln net.Listener
+	if err == nil {
+		ln, err = net.Listen("tcp", s.opts.Addr)
+	}
+	if err != nil {
+		s.running.CompareAndSwap(true, false)
+		return err
+	}
+	s.listening.Store(true)
+
+	if s.opts.TLS() {
+		err = s.srv.ServeTLS(ln, s.opts.TLSCertFile, s.opts.TLSKeyFile)
+	} else {
+		err = s.srv.Serve(ln)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.running.CompareAndSwap(true, false)
+		return err
+	}
+
+	return nil
+}
+
+// Ready reports whether the debug server accepts connections.
+func (s *Server) Ready() bool {
+	return s.running.Load() && s.listening.Load()
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	if s.running.CompareAndSwap(true, false) {
+		s.listening.Store(false)
+
+		tctx, cancel := context.WithTimeout(ctx, s.opts.ShutdownTimeout)
+		defer cancel()
+
+		if err := s.srv.Shutdown(tctx); err != nil {
+			s.srv.Close()
+			return fmt.Errorf("could not stop debug server gracefully: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func tlsConfig(opts Options) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read debug client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.ClientCAFile)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,54 @@
+package debug
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenIsRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	opts := DefaultOptions()
+	opts.Token = "s3cr3t"
+
+	router := gin.New()
+	Register(router, opts)
+
+	for _, tc := range []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer s3cr3t", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/debug/vars", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.code, w.Code, tc.auth)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	opts := DefaultOptions()
+	assert.NoError(t, opts.Validate())
+
+	opts.Mode = "sometimes"
+	assert.Error(t, opts.Validate())
+
+	opts = DefaultOptions()
+	opts.TLSCertFile = "tls.crt"
+	assert.Error(t, opts.Validate())
+
+	opts.TLSKeyFile = "tls.key"
+	assert.NoError(t, opts.Validate())
+}
@@ -0,0 +1,246 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sco1237896/sco-backend/pkg/tracing"
+)
+
+// State is the lifecycle state of a check.
+type State string
+
+const (
+	// StatePending is reported until a startup check passes for the first time.
+	StatePending State = "pending"
+	// StatePassing is reported when the check succeeded.
+	StatePassing State = "passing"
+	// StateFailing is reported when the check failed, which fails the probe.
+	StateFailing State = "failing"
+	// StateDegraded is reported when the check returned an error wrapped with
+	// Degraded, which is reported without failing the probe.
+	StateDegraded State = "degraded"
+)
+
+// Check reports the health of a dependency. It must honour the deadline of
+// ctx, although a check that does not is abandoned once its timeout expires.
+type Check func(ctx context.Context) error
+
+type degradedError struct {
+	err error
+}
+
+func (e *degradedError) Error() string { return e.err.Error() }
+func (e *degradedError) Unwrap() error { return e.err }
+
+// Degraded marks err as a degraded dependency: the check is reported as
+// degraded but does not fail the probe it belongs to.
+func Degraded(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &degradedError{err: err}
+}
+
+// Result is the outcome of the last run of a check.
+type Result struct {
+	State       State      `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	Duration    string     `json:"duration"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+const (
+	kindLiveness  = "liveness"
+	kindReadiness = "readiness"
+	kindStartup   = "startup"
+)
+
+// CheckOption customizes a single registered check.
+type CheckOption func(*check)
+
+// WithInterval sets how often the check is run in the background, overriding
+// Options.CheckInterval. Zero disables background runs for the check.
+func WithInterval(interval time.Duration) CheckOption {
+	return func(c *check) {
+		c.interval = interval
+	}
+}
+
+// check is a registered check together with its cached result.
+type check struct {
+	kind     string
+	name     string
+	fn       Check
+	interval time.Duration
+
+	// latch makes the check stop running once it passed, as startup checks do.
+	latch bool
+
+	// cancel stops the background runs of the check, if any.
+	cancel context.CancelFunc
+	// scheduled is set while the check runs in the background, probes then
+	// serve its last result instead of running it.
+	scheduled atomic.Bool
+	// removed is set once the check is unregistered, so a run still in
+	// flight does not publish its metrics again. Guarded by metricsMutex.
+	removed bool
+
+	// mu serializes runs, so concurrent probes share a single evaluation.
+	mu          sync.Mutex
+	result      Result
+	lastSuccess time.Time
+	history     *ring
+}
+
+func (s *Service) newCheck(kind string, name string, fn Check, opts ...CheckOption) *check {
+	c := &check{
+		kind:     kind,
+		name:     name,
+		fn:       fn,
+		interval: s.opts.CheckInterval,
+		latch:    kind == kindStartup,
+		result:   Result{State: StatePending},
+		history:  newRing(s.opts.HistorySize),
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// evaluate returns the last result of the check if it runs in the background,
+// or if it is younger than the cache TTL, otherwise it runs the check with the
+// configured timeout. Forced evaluations, as done by the scheduler, always
+// run the check.
+func (s *Service) evaluate(ctx context.Context, c *check, force bool) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.latch && c.result.State == StatePassing {
+		return c.result
+	}
+	if !force && !c.result.CheckedAt.IsZero() && (c.scheduled.Load() || time.Since(c.result.CheckedAt) < s.opts.CacheTTL) {
+		return c.result
+	}
+
+	// the result is shared with other probes, so it must not be affected by
+	// the cancellation of the request that happened to trigger the run
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.opts.CheckTimeout)
+	defer cancel()
+
+	ctx, span := s.tracer.Start(ctx, "health.check", trace.WithAttributes(
+		attribute.String("health.check", c.name),
+		attribute.String("health.kind", c.kind),
+	))
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", s.opts.CheckTimeout)
+	}
+
+	tracing.End(span, err)
+
+	previous := c.result.State
+
+	duration := time.Since(start)
+
+	c.result = Result{
+		State:     StatePassing,
+		Duration:  duration.String(),
+		CheckedAt: time.Now(),
+	}
+
+	var degraded *degradedError
+	switch {
+	case err == nil:
+		c.lastSuccess = c.result.CheckedAt
+	case errors.As(err, &degraded):
+		c.result.State = StateDegraded
+		c.result.Error = err.Error()
+	case c.latch:
+		c.result.State = StatePending
+		c.result.Error = err.Error()
+	default:
+		c.result.State = StateFailing
+		c.result.Error = err.Error()
+	}
+	if !c.lastSuccess.IsZero() {
+		lastSuccess := c.lastSuccess
+		c.result.LastSuccess = &lastSuccess
+	}
+
+	c.history.add(c.result)
+
+	transitioned := previous != c.result.State
+	if transitioned {
+		s.transition(ctx, c, previous, c.result)
+	}
+
+	recordMetrics(c, c.result, duration, transitioned)
+
+	return c.result
+}
+
+// evaluateAll runs the given checks concurrently and collects their results by name.
+func (s *Service) evaluateAll(ctx context.Context, checks []*check) map[string]Result {
+	results := make(map[string]Result, len(checks))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c *check) {
+			defer wg.Done()
+
+			r := s.evaluate(ctx, c, false)
+
+			mu.Lock()
+			results[c.name] = r
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// aggregate returns the overall state of a set of results: failing and pending
+// results take precedence over degraded ones, which take precedence over passing.
+func aggregate(results map[string]Result) State {
+	state := StatePassing
+
+	for _, r := range results {
+		switch {
+		case r.State == StateFailing:
+			return StateFailing
+		case r.State == StatePending:
+			state = StatePending
+		case r.State == StateDegraded && state == StatePassing:
+			state = StateDegraded
+		}
+	}
+
+	return state
+}
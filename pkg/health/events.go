@@ -0,0 +1,102 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// eventQueueSize bounds the transitions waiting to be posted to the webhook,
+// later ones are dropped while it is full.
+const eventQueueSize = 64
+
+// Transition is emitted when a check changes state.
+type Transition struct {
+	Kind  string    `json:"kind"`
+	Check string    `json:"check"`
+	From  State     `json:"from"`
+	To    State     `json:"to"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// transition logs the state change of a check and, if configured, queues it
+// for the notification webhook without blocking the check.
+func (s *Service) transition(ctx context.Context, c *check, from State, r Result) {
+	t := Transition{
+		Kind:  c.kind,
+		Check: c.name,
+		From:  from,
+		To:    r.State,
+		Error: r.Error,
+		Time:  r.CheckedAt,
+	}
+
+	level := slog.LevelInfo
+	if t.To == StateFailing || t.To == StateDegraded {
+		level = slog.LevelWarn
+	}
+
+	s.l.Log(ctx, level, "health check state changed",
+		slog.String("kind", t.Kind),
+		slog.String("check", t.Check),
+		slog.String("from", string(t.From)),
+		slog.String("to", string(t.To)),
+		slog.String("error", t.Error),
+	)
+
+	if s.opts.WebhookURL != "" {
+		select {
+		case s.events <- t:
+		default:
+			s.l.Warn("dropping health check transition, the webhook is not keeping up", slog.String("check", t.Check))
+		}
+	}
+}
+
+// notifier posts the queued transitions to the webhook one at a time, in
+// the order they happened, until ctx is done.
+func (s *Service) notifier(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-s.events:
+			if err := s.notify(ctx, t); err != nil {
+				s.l.Error("failed to notify health check transition", slog.String("check", t.Check), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (s *Service) notify(ctx context.Context, t Transition) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
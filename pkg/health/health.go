@@ -5,38 +5,62 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/gin-contrib/expvar"
-	"github.com/gin-contrib/pprof"
-
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sco1237896/sco-backend/pkg/debug"
+	"github.com/sco1237896/sco-backend/pkg/tracing"
 )
 
+// drainCheck is the readiness check that fails while shutting down.
+const drainCheck = "shutdown"
+
 type Options struct {
 	Enabled         bool
 	Addr            string
 	Prefix          string
 	ShutdownTimeout time.Duration
+	CheckTimeout    time.Duration
+	CacheTTL        time.Duration
+	CheckInterval   time.Duration
+	HistorySize     int
+	WebhookURL      string
+	WebhookTimeout  time.Duration
+	Debug           debug.Options
 }
 
 type Service struct {
 	l       *slog.Logger
+	tracer  trace.Tracer
 	running atomic.Bool
 	router  *gin.Engine
 	srv     *http.Server
 	opts    Options
 
+	// listening is set once the server accepts connections
+	listening atomic.Bool
+
 	checksMutex     sync.RWMutex
-	livenessChecks  map[string]Check
-	readinessChecks map[string]Check
-}
+	livenessChecks  map[string]*check
+	readinessChecks map[string]*check
+	startupChecks   map[string]*check
 
-type Check func() error
+	// events queues the transitions for the webhook notifier
+	events chan Transition
+
+	schedulerCtx    context.Context
+	schedulerCancel context.CancelFunc
+	schedulerWG     sync.WaitGroup
+}
 
 func DefaultOptions() Options {
 	return Options{
@@ -44,26 +68,56 @@ func DefaultOptions() Options {
 		Addr:            ":8081",
 		Prefix:          "",
 		ShutdownTimeout: 3 * time.Second,
+		CheckTimeout:    800 * time.Millisecond,
+		CacheTTL:        2 * time.Second,
+		CheckInterval:   10 * time.Second,
+		HistorySize:     20,
+		WebhookURL:      "",
+		WebhookTimeout:  5 * time.Second,
+		Debug:           debug.DefaultOptions(),
+	}
+}
+
+func (o Options) Validate() error {
+	if o.CheckTimeout <= 0 {
+		return errors.New("the health check timeout must be positive")
+	}
+	if o.CheckInterval < 0 || o.CacheTTL < 0 || o.ShutdownTimeout < 0 || o.WebhookTimeout < 0 {
+		return errors.New("health check durations must not be negative")
 	}
+	if o.HistorySize < 0 {
+		return errors.New("the health check history size must not be negative")
+	}
+	if o.WebhookURL != "" {
+		if u, err := url.Parse(o.WebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid health check webhook URL %q", o.WebhookURL)
+		}
+	}
+
+	return o.Debug.Validate()
 }
 
 func New(opts Options, logger *slog.Logger) *Service {
 	s := Service{
-		opts: opts,
+		opts:   opts,
+		events: make(chan Transition, eventQueueSize),
 	}
 	s.l = logger.WithGroup("health")
+	s.tracer = tracing.Tracer("github.com/sco1237896/sco-backend/pkg/health")
 
 	s.router = gin.New()
 	s.router.Use(s.log)
 	s.router.Use(gin.Recovery())
 	s.router.GET(path.Join(opts.Prefix, "/health", "/ready"), s.ready)
 	s.router.GET(path.Join(opts.Prefix, "/health", "/live"), s.live)
+	s.router.GET(path.Join(opts.Prefix, "/health", "/started"), s.started)
+	s.router.GET(path.Join(opts.Prefix, "/health", "/history"), s.history)
 
-	// register pprof middleware endpoints
-	pprof.Register(s.router)
-
-	// register expvar endpoints
-	s.router.GET("/debug/vars", expvar.Handler())
+	// register pprof and expvar endpoints, unless they are disabled or
+	// served on their own listener
+	if opts.Debug.Mode == debug.ModeEnabled {
+		debug.Register(s.router, opts.Debug)
+	}
 
 	s.srv = &http.Server{
 		ReadTimeout:       1 * time.Second,
@@ -74,17 +128,28 @@ func New(opts Options, logger *slog.Logger) *Service {
 		Handler:           s.router,
 	}
 
-	s.readinessChecks = make(map[string]Check)
-	s.livenessChecks = make(map[string]Check)
+	s.readinessChecks = make(map[string]*check)
+	s.livenessChecks = make(map[string]*check)
+	s.startupChecks = make(map[string]*check)
 
 	return &s
 }
 
-func (s *Service) Start(context.Context) error {
+func (s *Service) Start(ctx context.Context) error {
 	if s.running.CompareAndSwap(false, true) {
-		err := s.srv.ListenAndServe()
+		ln, err := net.Listen("tcp", s.srv.Addr)
+		if err != nil {
+			s.running.CompareAndSwap(true, false)
+			return err
+		}
+		s.listening.Store(true)
+
+		s.startScheduler(ctx)
+
+		err = s.srv.Serve(ln)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.running.CompareAndSwap(true, false)
+			s.stopScheduler()
 			return err
 		}
 	}
@@ -92,8 +157,17 @@ func (s *Service) Start(context.Context) error {
 	return nil
 }
 
+// Ready reports whether the health server accepts connections.
+func (s *Service) Ready() bool {
+	return s.running.Load() && s.listening.Load()
+}
+
 func (s *Service) Stop(ctx context.Context) error {
 	if s.running.CompareAndSwap(true, false) {
+		s.listening.Store(false)
+
+		s.stopScheduler()
+
 		tctx, cancel := context.WithTimeout(ctx, s.opts.ShutdownTimeout)
 		defer cancel()
 
@@ -106,32 +180,56 @@ func (s *Service) Stop(ctx context.Context) error {
 	return nil
 }
 
-func (s *Service) AddLivenessCheck(name string, check Check) {
+// Drain makes the readiness probe fail from now on, so that load balancers
+// stop routing traffic here before the servers are stopped.
+func (s *Service) Drain() {
+	s.AddReadinessCheck(drainCheck, func(context.Context) error {
+		return errors.New("shutting down")
+	}, WithInterval(0))
+}
+
+func (s *Service) AddLivenessCheck(name string, check Check, opts ...CheckOption) {
 	s.checksMutex.Lock()
 	defer s.checksMutex.Unlock()
 
-	s.livenessChecks[name] = check
+	s.register(s.livenessChecks, s.newCheck(kindLiveness, name, check, opts...))
 }
 
 func (s *Service) RemoveLivenessCheck(name string) {
 	s.checksMutex.Lock()
 	defer s.checksMutex.Unlock()
 
-	delete(s.livenessChecks, name)
+	s.remove(s.livenessChecks, name)
 }
 
-func (s *Service) AddReadinessCheck(name string, check Check) {
+func (s *Service) AddReadinessCheck(name string, check Check, opts ...CheckOption) {
 	s.checksMutex.Lock()
 	defer s.checksMutex.Unlock()
 
-	s.readinessChecks[name] = check
+	s.register(s.readinessChecks, s.newCheck(kindReadiness, name, check, opts...))
 }
 
 func (s *Service) RemoveReadinessCheck(name string) {
 	s.checksMutex.Lock()
 	defer s.checksMutex.Unlock()
 
-	delete(s.readinessChecks, name)
+	s.remove(s.readinessChecks, name)
+}
+
+// AddStartupCheck registers a check reported by the startup probe. Once the
+// check passes it is latched and never run again.
+func (s *Service) AddStartupCheck(name string, check Check, opts ...CheckOption) {
+	s.checksMutex.Lock()
+	defer s.checksMutex.Unlock()
+
+	s.register(s.startupChecks, s.newCheck(kindStartup, name, check, opts...))
+}
+
+func (s *Service) RemoveStartupCheck(name string) {
+	s.checksMutex.Lock()
+	defer s.checksMutex.Unlock()
+
+	s.remove(s.startupChecks, name)
 }
 
 func (s *Service) ready(c *gin.Context) {
@@ -140,40 +238,50 @@ func (s *Service) ready(c *gin.Context) {
 func (s *Service) live(c *gin.Context) {
 	s.handle(c, s.livenessChecks)
 }
+func (s *Service) started(c *gin.Context) {
+	s.handle(c, s.startupChecks)
+}
 
-func (s *Service) handle(c *gin.Context, checks ...map[string]Check) {
-	checkResults := make(map[string]string)
-	status := http.StatusOK
+func (s *Service) handle(c *gin.Context, checks ...map[string]*check) {
+	ctx, span := s.tracer.Start(c.Request.Context(), "health "+c.FullPath())
+	defer span.End()
+
+	checkResults := s.evaluateAll(ctx, s.snapshot(checks...))
+	state := aggregate(checkResults)
 
-	for _, checks := range checks {
-		s.collectChecks(checks, checkResults, &status)
+	status := http.StatusOK
+	if state == StateFailing || state == StatePending {
+		status = http.StatusServiceUnavailable
 	}
 
+	span.SetAttributes(attribute.Int("http.status_code", status), attribute.String("health.state", string(state)))
+
 	switch c.Query("full") {
 	case "true":
 		c.JSON(status, gin.H{
-			"status": "OK",
+			"status": state,
 			"data":   checkResults,
 		})
 	default:
 		c.JSON(status, gin.H{
-			"status": "OK",
+			"status": state,
 		})
 	}
 }
 
-func (s *Service) collectChecks(checks map[string]Check, resultsOut map[string]string, statusOut *int) {
+// snapshot copies the registered checks, so they can be run without holding the lock.
+func (s *Service) snapshot(checks ...map[string]*check) []*check {
 	s.checksMutex.RLock()
 	defer s.checksMutex.RUnlock()
 
-	for name, check := range checks {
-		if err := check(); err != nil {
-			*statusOut = http.StatusServiceUnavailable
-			resultsOut[name] = err.Error()
-		} else {
-			resultsOut[name] = "OK"
+	out := make([]*check, 0)
+	for _, m := range checks {
+		for _, c := range m {
+			out = append(out, c)
 		}
 	}
+
+	return out
 }
 
 func (s *Service) log(c *gin.Context) {
@@ -0,0 +1,295 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+type fullResponse struct {
+	Status State             `json:"status"`
+	Data   map[string]Result `json:"data"`
+}
+
+func probe(t *testing.T, s *Service, path string) (int, fullResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, path+"?full=true", nil)
+	s.router.ServeHTTP(w, req)
+
+	resp := fullResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	return w.Code, resp
+}
+
+func TestChecksRunConcurrentlyWithTimeout(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.CheckTimeout = 200 * time.Millisecond
+	s := New(opts, logger.L)
+
+	s.AddReadinessCheck("slow1", func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	s.AddReadinessCheck("slow2", func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	s.AddReadinessCheck("hung", func(ctx context.Context) error {
+		select {}
+	})
+
+	start := time.Now()
+	code, resp := probe(t, s, "/health/ready")
+
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatePassing, resp.Data["slow1"].State)
+	assert.NotNil(t, resp.Data["slow1"].LastSuccess)
+	assert.NotEmpty(t, resp.Data["slow1"].Duration)
+	assert.Equal(t, StateFailing, resp.Data["hung"].State)
+	assert.Contains(t, resp.Data["hung"].Error, "timed out")
+	assert.Nil(t, resp.Data["hung"].LastSuccess)
+}
+
+func TestCheckResultsAreCached(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.CacheTTL = time.Hour
+	s := New(opts, logger.L)
+
+	var runs atomic.Int32
+	s.AddLivenessCheck("counted", func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("broken")
+	})
+
+	for i := 0; i < 3; i++ {
+		code, resp := probe(t, s, "/health/live")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "broken", resp.Data["counted"].Error)
+	}
+
+	assert.Equal(t, int32(1), runs.Load())
+}
+
+func TestStartupChecksLatch(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.CacheTTL = 0
+	s := New(opts, logger.L)
+
+	var ready atomic.Bool
+	s.AddStartupCheck("synced", func(ctx context.Context) error {
+		if ready.Load() {
+			return nil
+		}
+		return errors.New("not synced")
+	})
+
+	code, resp := probe(t, s, "/health/started")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatePending, resp.Status)
+
+	ready.Store(true)
+	code, _ = probe(t, s, "/health/started")
+	assert.Equal(t, http.StatusOK, code)
+
+	// once passed, startup checks are not run again
+	ready.Store(false)
+	code, resp = probe(t, s, "/health/started")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatePassing, resp.Data["synced"].State)
+}
+
+func TestProbesServeScheduledResults(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.CacheTTL = 0
+	s := New(opts, logger.L)
+
+	var runs, startupRuns atomic.Int32
+	s.AddReadinessCheck("scheduled", func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}, WithInterval(time.Hour))
+	s.AddStartupCheck("latched", func(ctx context.Context) error {
+		startupRuns.Add(1)
+		return nil
+	}, WithInterval(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s.startScheduler(ctx)
+	assert.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		code, _ := probe(t, s, "/health/ready")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, int32(1), runs.Load())
+
+	// latched checks are no longer scheduled once they passed
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), startupRuns.Load())
+
+	s.stopScheduler()
+}
+
+func TestDegradedChecksDoNotFailReadiness(t *testing.T) {
+	logger.Init(true)
+
+	s := New(DefaultOptions(), logger.L)
+	s.AddReadinessCheck("ok", func(ctx context.Context) error {
+		return nil
+	})
+	s.AddReadinessCheck("slow-dependency", func(ctx context.Context) error {
+		return Degraded(errors.New("latency above threshold"))
+	})
+
+	code, resp := probe(t, s, "/health/ready")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateDegraded, resp.Status)
+	assert.Equal(t, StateDegraded, resp.Data["slow-dependency"].State)
+	assert.Equal(t, "latency above threshold", resp.Data["slow-dependency"].Error)
+}
+
+func TestSchedulerRecordsHistoryAndTransitions(t *testing.T) {
+	logger.Init(true)
+
+	transitions := make(chan Transition, 100)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr := Transition{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&tr))
+		select {
+		case transitions <- tr:
+		default:
+		}
+	}))
+	t.Cleanup(webhook.Close)
+
+	opts := DefaultOptions()
+	opts.Addr = "localhost:0"
+	opts.HistorySize = 3
+	opts.WebhookURL = webhook.URL
+	s := New(opts, logger.L)
+
+	var runs atomic.Int32
+	s.AddReadinessCheck("flapping", func(ctx context.Context) error {
+		if runs.Add(1)%2 == 0 {
+			return errors.New("down")
+		}
+		return nil
+	}, WithInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s.startScheduler(ctx)
+	assert.Eventually(t, func() bool { return runs.Load() >= 5 }, time.Second, 5*time.Millisecond)
+	s.stopScheduler()
+
+	tr := <-transitions
+	assert.Equal(t, "flapping", tr.Check)
+	assert.Equal(t, kindReadiness, tr.Kind)
+
+	// transitions are posted in the order they happened
+	for len(transitions) > 0 {
+		next := <-transitions
+		assert.Equal(t, tr.To, next.From)
+		assert.False(t, next.Time.Before(tr.Time))
+		tr = next
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/health/history", nil)
+	s.router.ServeHTTP(w, req)
+
+	resp := map[string]map[string]checkHistory{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp[kindReadiness]["flapping"].History, 3)
+	assert.Equal(t, "10ms", resp[kindReadiness]["flapping"].Interval)
+}
+
+func TestChecksArePublishedAsMetrics(t *testing.T) {
+	logger.Init(true)
+
+	s := New(DefaultOptions(), logger.L)
+	s.AddReadinessCheck("metered", func(ctx context.Context) error {
+		return Degraded(errors.New("slow"))
+	})
+
+	probe(t, s, "/health/ready")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/debug/vars", nil)
+	s.router.ServeHTTP(w, req)
+
+	vars := struct {
+		Health struct {
+			Status      map[string]map[string]float64 `json:"status"`
+			Transitions map[string]map[string]int     `json:"transitions"`
+			Duration    map[string]map[string]struct {
+				Buckets map[string]int `json:"buckets"`
+				Count   int            `json:"count"`
+			} `json:"duration"`
+		} `json:"health"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &vars))
+
+	assert.InDelta(t, 0.5, vars.Health.Status[kindReadiness]["metered"], 0.001)
+	assert.Equal(t, 1, vars.Health.Transitions[kindReadiness]["metered"])
+	assert.Equal(t, 1, vars.Health.Duration[kindReadiness]["metered"].Count)
+	assert.Equal(t, 1, vars.Health.Duration[kindReadiness]["metered"].Buckets["+Inf"])
+
+	// a removed check is no longer published
+	s.RemoveReadinessCheck("metered")
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	vars.Health.Status, vars.Health.Transitions, vars.Health.Duration = nil, nil, nil
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &vars))
+
+	assert.NotContains(t, vars.Health.Status[kindReadiness], "metered")
+	assert.NotContains(t, vars.Health.Transitions[kindReadiness], "metered")
+	assert.NotContains(t, vars.Health.Duration[kindReadiness], "metered")
+}
+
+func TestDrainFailsReadiness(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.CacheTTL = time.Hour
+	s := New(opts, logger.L)
+	s.AddReadinessCheck("ok", func(ctx context.Context) error {
+		return nil
+	})
+
+	code, _ := probe(t, s, "/health/ready")
+	assert.Equal(t, http.StatusOK, code)
+
+	// draining is reported right away, regardless of cached results
+	s.Drain()
+
+	code, resp := probe(t, s, "/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StateFailing, resp.Status)
+	assert.Equal(t, "shutting down", resp.Data[drainCheck].Error)
+}
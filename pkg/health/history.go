@@ -0,0 +1,77 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ring is a fixed size buffer keeping the most recent results of a check.
+type ring struct {
+	items []Result
+	next  int
+	full  bool
+}
+
+func newRing(size int) *ring {
+	return &ring{items: make([]Result, size)}
+}
+
+func (r *ring) add(res Result) {
+	if len(r.items) == 0 {
+		return
+	}
+
+	r.items[r.next] = res
+	r.next = (r.next + 1) % len(r.items)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the results in the buffer, oldest first.
+func (r *ring) list() []Result {
+	if !r.full {
+		return append([]Result(nil), r.items[:r.next]...)
+	}
+
+	return append(append([]Result(nil), r.items[r.next:]...), r.items[:r.next]...)
+}
+
+// checkHistory is the history of a check as served by /health/history.
+type checkHistory struct {
+	State    State    `json:"state"`
+	Interval string   `json:"interval,omitempty"`
+	History  []Result `json:"history"`
+}
+
+func (s *Service) history(c *gin.Context) {
+	s.checksMutex.RLock()
+	defer s.checksMutex.RUnlock()
+
+	out := make(map[string]map[string]checkHistory)
+	for kind, checks := range map[string]map[string]*check{
+		kindLiveness:  s.livenessChecks,
+		kindReadiness: s.readinessChecks,
+		kindStartup:   s.startupChecks,
+	} {
+		out[kind] = make(map[string]checkHistory, len(checks))
+
+		for name, chk := range checks {
+			chk.mu.Lock()
+			h := checkHistory{
+				State:   chk.result.State,
+				History: chk.history.list(),
+			}
+			chk.mu.Unlock()
+
+			if chk.interval > 0 {
+				h.Interval = chk.interval.String()
+			}
+
+			out[kind][name] = h
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
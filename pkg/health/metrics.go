@@ -0,0 +1,152 @@
+package health
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the check duration histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The results of every check are published under the "health" expvar, keyed
+// by metric, check kind and check name:
+//
+//	health.status.<kind>.<name>       1 passing, 0.5 degraded, 0 failing or pending
+//	health.duration.<kind>.<name>     cumulative histogram of run durations in seconds
+//	health.transitions.<kind>.<name>  number of state changes
+var (
+	healthVars        = expvar.NewMap("health")
+	statusGauge       = new(expvar.Map).Init()
+	durationHistogram = new(expvar.Map).Init()
+	transitionCounter = new(expvar.Map).Init()
+
+	metricsMutex sync.Mutex
+)
+
+func init() {
+	healthVars.Set("status", statusGauge)
+	healthVars.Set("duration", durationHistogram)
+	healthVars.Set("transitions", transitionCounter)
+}
+
+// recordMetrics publishes the outcome of a check run.
+func recordMetrics(c *check, r Result, duration time.Duration, transitioned bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	if c.removed {
+		return
+	}
+
+	status, ok := kindVars(statusGauge, c.kind).Get(c.name).(*expvar.Float)
+	if !ok {
+		status = new(expvar.Float)
+		kindVars(statusGauge, c.kind).Set(c.name, status)
+	}
+	status.Set(statusValue(r.State))
+
+	hist, ok := kindVars(durationHistogram, c.kind).Get(c.name).(*histogram)
+	if !ok {
+		hist = newHistogram(durationBuckets)
+		kindVars(durationHistogram, c.kind).Set(c.name, hist)
+	}
+	hist.observe(duration.Seconds())
+
+	transitions, ok := kindVars(transitionCounter, c.kind).Get(c.name).(*expvar.Int)
+	if !ok {
+		transitions = new(expvar.Int)
+		kindVars(transitionCounter, c.kind).Set(c.name, transitions)
+	}
+	if transitioned {
+		transitions.Add(1)
+	}
+}
+
+// removeMetrics deletes the metrics of a check that is unregistered.
+func removeMetrics(c *check) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	c.removed = true
+	for _, metric := range []*expvar.Map{statusGauge, durationHistogram, transitionCounter} {
+		kindVars(metric, c.kind).Delete(c.name)
+	}
+}
+
+// kindVars returns the map of a check kind below a metric, creating it if needed.
+// The caller must hold metricsMutex.
+func kindVars(metric *expvar.Map, kind string) *expvar.Map {
+	if m, ok := metric.Get(kind).(*expvar.Map); ok {
+		return m
+	}
+
+	m := new(expvar.Map).Init()
+	metric.Set(kind, m)
+
+	return m
+}
+
+func statusValue(state State) float64 {
+	switch state {
+	case StatePassing:
+		return 1
+	case StateDegraded:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+// histogram is a cumulative histogram published as an expvar.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+var _ expvar.Var = &histogram{}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// String renders the histogram as JSON, with a bucket per upper bound.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[string]uint64, len(h.bounds)+1)
+	for i, b := range h.bounds {
+		buckets[strconv.FormatFloat(b, 'g', -1, 64)] = h.buckets[i]
+	}
+	buckets["+Inf"] = h.count
+
+	out, _ := json.Marshal(map[string]any{
+		"buckets": buckets,
+		"sum":     h.sum,
+		"count":   h.count,
+	})
+
+	return string(out)
+}
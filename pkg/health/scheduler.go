@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"time"
+)
+
+// register adds c to checks, replacing and stopping a check with the same
+// name, and schedules it if the background scheduler is running.
+// The caller must hold checksMutex.
+func (s *Service) register(checks map[string]*check, c *check) {
+	s.unregister(checks, c.name)
+
+	checks[c.name] = c
+
+	if s.schedulerCtx != nil {
+		s.schedule(c)
+	}
+}
+
+// unregister removes a check and stops its background runs.
+// The caller must hold checksMutex.
+func (s *Service) unregister(checks map[string]*check, name string) {
+	if c, ok := checks[name]; ok && c.cancel != nil {
+		c.cancel()
+	}
+
+	delete(checks, name)
+}
+
+// remove unregisters a check and deletes its metrics, so a removed check is
+// not published with stale values. The caller must hold checksMutex.
+func (s *Service) remove(checks map[string]*check, name string) {
+	if c, ok := checks[name]; ok {
+		s.unregister(checks, name)
+		removeMetrics(c)
+	}
+}
+
+// startScheduler runs every registered check, and every check registered
+// later on, in the background on its own interval, and the notifier of the
+// transitions.
+func (s *Service) startScheduler(ctx context.Context) {
+	s.checksMutex.Lock()
+	defer s.checksMutex.Unlock()
+
+	s.schedulerCtx, s.schedulerCancel = context.WithCancel(ctx)
+
+	if s.opts.WebhookURL != "" {
+		s.schedulerWG.Add(1)
+		go func() {
+			defer s.schedulerWG.Done()
+			s.notifier(s.schedulerCtx)
+		}()
+	}
+
+	for _, checks := range []map[string]*check{s.livenessChecks, s.readinessChecks, s.startupChecks} {
+		for _, c := range checks {
+			s.schedule(c)
+		}
+	}
+}
+
+func (s *Service) stopScheduler() {
+	s.checksMutex.Lock()
+	if s.schedulerCancel != nil {
+		s.schedulerCancel()
+	}
+	s.schedulerCtx = nil
+	s.schedulerCancel = nil
+	s.checksMutex.Unlock()
+
+	s.schedulerWG.Wait()
+}
+
+// schedule starts the background runs of c, which stop once a latched check
+// passed. The caller must hold checksMutex.
+func (s *Service) schedule(c *check) {
+	if c.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.schedulerCtx)
+	c.cancel = cancel
+	c.scheduled.Store(true)
+
+	s.schedulerWG.Add(1)
+	go func() {
+		defer s.schedulerWG.Done()
+		defer c.scheduled.Store(false)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			if r := s.evaluate(ctx, c, true); c.latch && r.State == StatePassing {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+// Package lifecycle starts and stops the components of a command in
+// dependency order.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Component is a long running part of a command, such as a server.
+type Component interface {
+	// Start runs the component until it is stopped. An error returned by
+	// Start, including a failure to start, stops every other component.
+	Start(ctx context.Context) error
+	// Stop stops the component, which makes Start return.
+	Stop(ctx context.Context) error
+	// Ready reports whether the component is up, components that depend on
+	// it are only started once it is.
+	Ready() bool
+}
+
+type Options struct {
+	// StartTimeout is how long a component has to become ready.
+	StartTimeout time.Duration
+	// ReadyInterval is how often readiness is polled while starting.
+	ReadyInterval time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		StartTimeout:  30 * time.Second,
+		ReadyInterval: 10 * time.Millisecond,
+	}
+}
+
+type entry struct {
+	name      string
+	component Component
+	dependsOn []string
+}
+
+// Manager runs a set of components.
+type Manager struct {
+	l          *slog.Logger
+	opts       Options
+	components []entry
+}
+
+func New(opts Options, l *slog.Logger) *Manager {
+	return &Manager{
+		l:    l.WithGroup("lifecycle"),
+		opts: opts,
+	}
+}
+
+// Add registers a component, which is started after the components it
+// depends on are ready and stopped before them.
+func (m *Manager) Add(name string, c Component, dependsOn ...string) {
+	m.components = append(m.components, entry{name: name, component: c, dependsOn: dependsOn})
+}
+
+// Run starts every component and waits until ctx is done or a component
+// fails, then stops the started components in reverse order. Components are
+// stopped with stopCtx, which is not canceled with ctx so they can drain, and
+// whose cancellation makes them stop right away. Run returns the cause of the
+// cancellation of stopCtx if it interrupted the stop, or else the first error
+// of a component, if any.
+func (m *Manager) Run(ctx context.Context, stopCtx context.Context) error {
+	order, err := m.order()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	var startErr error
+	var started []entry
+	for _, e := range order {
+		e := e
+
+		m.l.InfoContext(ctx, "Starting component", slog.String("component", e.name))
+
+		g.Go(func() error {
+			if err := e.component.Start(gctx); err != nil {
+				return fmt.Errorf("%s: %w", e.name, err)
+			}
+			return nil
+		})
+		started = append(started, e)
+
+		if err := m.waitReady(gctx, e); err != nil {
+			// unless another component failed, the component timed out
+			if gctx.Err() == nil {
+				startErr = err
+				cancel()
+			}
+			break
+		}
+	}
+
+	<-gctx.Done()
+
+	for i := len(started) - 1; i >= 0; i-- {
+		e := started[i]
+
+		m.l.InfoContext(ctx, "Stopping component", slog.String("component", e.name))
+		if err := e.component.Stop(stopCtx); err != nil {
+			m.l.ErrorContext(ctx, "error stopping component", slog.String("component", e.name), slog.Any("error", err))
+		}
+	}
+
+	err = g.Wait()
+	if stopCtx.Err() != nil {
+		return context.Cause(stopCtx)
+	}
+	if err != nil {
+		return err
+	}
+
+	return startErr
+}
+
+// waitReady waits for a component to be ready. It returns an error on timeout
+// or if ctx is done first, which happens when a component failed.
+func (m *Manager) waitReady(ctx context.Context, e entry) error {
+	ticker := time.NewTicker(m.opts.ReadyInterval)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(m.opts.StartTimeout)
+	defer timeout.Stop()
+
+	for !e.component.Ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			err := fmt.Errorf("%s was not ready after %s", e.name, m.opts.StartTimeout)
+			m.l.ErrorContext(ctx, "error starting component", slog.String("component", e.name), slog.Any("error", err))
+			return err
+		case <-ticker.C:
+		}
+	}
+
+	m.l.InfoContext(ctx, "Component ready", slog.String("component", e.name))
+
+	return nil
+}
+
+// order sorts the components so that each comes after its dependencies,
+// keeping the order they were added in otherwise.
+func (m *Manager) order() ([]entry, error) {
+	byName := make(map[string]entry, len(m.components))
+	for _, e := range m.components {
+		if _, ok := byName[e.name]; ok {
+			return nil, fmt.Errorf("component %s added twice", e.name)
+		}
+		byName[e.name] = e
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := make(map[string]int, len(m.components))
+	order := make([]entry, 0, len(m.components))
+
+	var visit func(e entry) error
+	visit = func(e entry) error {
+		switch state[e.name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.New("dependency cycle through component " + e.name)
+		}
+
+		state[e.name] = visiting
+		for _, d := range e.dependsOn {
+			dep, ok := byName[d]
+			if !ok {
+				return fmt.Errorf("component %s depends on unknown component %s", e.name, d)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[e.name] = visited
+
+		order = append(order, e)
+
+		return nil
+	}
+
+	for _, e := range m.components {
+		if err := visit(e); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
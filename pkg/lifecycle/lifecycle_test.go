@@ -0,0 +1,183 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+// recorder collects the start and stop events of components.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+type fakeComponent struct {
+	name     string
+	rec      *recorder
+	startErr error
+	ready    atomic.Bool
+	stopped  chan struct{}
+}
+
+func newFake(name string, rec *recorder) *fakeComponent {
+	return &fakeComponent{name: name, rec: rec, stopped: make(chan struct{})}
+}
+
+func (f *fakeComponent) Start(context.Context) error {
+	f.rec.add("start " + f.name)
+	if f.startErr != nil {
+		return f.startErr
+	}
+
+	f.ready.Store(true)
+	<-f.stopped
+
+	return nil
+}
+
+func (f *fakeComponent) Stop(context.Context) error {
+	f.rec.add("stop " + f.name)
+	close(f.stopped)
+
+	return nil
+}
+
+func (f *fakeComponent) Ready() bool {
+	return f.ready.Load()
+}
+
+func TestRunInDependencyOrder(t *testing.T) {
+	logger.Init(true)
+
+	rec := &recorder{}
+	m := New(DefaultOptions(), logger.L)
+	m.Add("server", newFake("server", rec), "health", "client")
+	m.Add("health", newFake("health", rec))
+	m.Add("client", newFake("client", rec), "health")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx, context.Background()) }()
+
+	assert.Eventually(t, func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.events) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, []string{
+		"start health", "start client", "start server",
+		"stop server", "stop client", "stop health",
+	}, rec.events)
+}
+
+func TestFailedStartAbortsRun(t *testing.T) {
+	logger.Init(true)
+
+	rec := &recorder{}
+	broken := newFake("server", rec)
+	broken.startErr = errors.New("address already in use")
+
+	m := New(DefaultOptions(), logger.L)
+	m.Add("health", newFake("health", rec))
+	m.Add("server", broken, "health")
+	m.Add("metrics", newFake("metrics", rec), "server")
+
+	err := m.Run(context.Background(), context.Background())
+	assert.EqualError(t, err, "server: address already in use")
+
+	// components that depend on the failed one are never started
+	assert.NotContains(t, rec.events, "start metrics")
+	assert.Contains(t, rec.events, "stop health")
+}
+
+func TestStartTimeout(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.StartTimeout = 50 * time.Millisecond
+
+	m := New(opts, logger.L)
+	m.Add("slow", &neverReady{newFake("slow", &recorder{})})
+
+	assert.EqualError(t, m.Run(context.Background(), context.Background()), "slow was not ready after 50ms")
+}
+
+func TestDependencyErrors(t *testing.T) {
+	logger.Init(true)
+
+	m := New(DefaultOptions(), logger.L)
+	m.Add("a", newFake("a", &recorder{}), "b")
+	m.Add("b", newFake("b", &recorder{}), "a")
+	assert.ErrorContains(t, m.Run(context.Background(), context.Background()), "dependency cycle")
+
+	m = New(DefaultOptions(), logger.L)
+	m.Add("a", newFake("a", &recorder{}), "missing")
+	assert.EqualError(t, m.Run(context.Background(), context.Background()), "component a depends on unknown component missing")
+}
+
+func TestInterruptedStop(t *testing.T) {
+	logger.Init(true)
+
+	rec := &recorder{}
+	m := New(DefaultOptions(), logger.L)
+	m.Add("health", newFake("health", rec))
+	m.Add("server", &draining{newFake("server", rec)}, "health")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopCtx, interrupt := context.WithCancelCause(context.Background())
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx, stopCtx) }()
+
+	assert.Eventually(t, func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.events) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	interrupt(errors.New("interrupted"))
+
+	// the draining server gives up, and the other components are still stopped
+	assert.EqualError(t, <-done, "interrupted")
+	assert.Contains(t, rec.events, "stop health")
+}
+
+// draining runs a component that drains until its stop context is done.
+type draining struct {
+	*fakeComponent
+}
+
+func (d *draining) Stop(ctx context.Context) error {
+	<-ctx.Done()
+	return d.fakeComponent.Stop(ctx)
+}
+
+// neverReady runs a component that never reports ready.
+type neverReady struct {
+	*fakeComponent
+}
+
+func (n *neverReady) Ready() bool {
+	return false
+}
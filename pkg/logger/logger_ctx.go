@@ -8,10 +8,25 @@ import (
 )
 
 const (
-	TraceIDAttr = "trace_id"
-	SpanIDAttr  = "span_id"
+	TraceIDAttr   = "trace_id"
+	SpanIDAttr    = "span_id"
+	RequestIDAttr = "request_id"
 )
 
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the given request ID, which is
+// then added to every record logged with that context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
 type ContextHandler struct {
 	slog.Handler
 }
@@ -39,12 +54,20 @@ func (h ContextHandler) WithGroup(name string) slog.Handler {
 }
 
 func (h ContextHandler) attrs(ctx context.Context) []slog.Attr {
-	span := trace.SpanFromContext(ctx)
-	if span == nil {
+	if ctx == nil {
 		return nil
 	}
 
-	attrs := make([]slog.Attr, 0, 2)
+	attrs := make([]slog.Attr, 0, 3)
+
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String(RequestIDAttr, id))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if span == nil {
+		return attrs
+	}
 
 	if span.SpanContext().HasTraceID() {
 		attrs = append(attrs, slog.String(TraceIDAttr, span.SpanContext().TraceID().String()))
@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+const redacted = "REDACTED"
+
+// Secret is a string, such as a token or a password, that is redacted when
+// printed, logged or marshalled. It can be bound to a command line flag.
+type Secret string
+
+// Value returns the secret in clear text.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Set implements pflag.Value. It rejects the redacted placeholder, so that
+// a redacted configuration cannot be loaded back by mistake.
+func (s *Secret) Set(v string) error {
+	if v == redacted {
+		return errors.New("the secret is redacted, set its value")
+	}
+
+	*s = Secret(v)
+	return nil
+}
+
+// Type implements pflag.Value.
+func (s *Secret) Type() string {
+	return "string"
+}
@@ -15,12 +15,21 @@ import (
 // from internal services using expvar.
 type Expvar struct {
 	host   string
-	tr     *http.Transport
 	client http.Client
 }
 
 // New creates a Expvar for collection metrics.
 func New(host string) (*Expvar, error) {
+	exp := Expvar{
+		host:   host,
+		client: newClient(),
+	}
+
+	return &exp, nil
+}
+
+// newClient creates the HTTP client used to collect metrics.
+func newClient() http.Client {
 	tr := http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -33,22 +42,20 @@ func New(host string) (*Expvar, error) {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
-	exp := Expvar{
-		host: host,
-		tr:   &tr,
-		client: http.Client{
-			Transport: &tr,
-			Timeout:   1 * time.Second,
-		},
+	return http.Client{
+		Transport: &tr,
+		Timeout:   1 * time.Second,
 	}
-
-	return &exp, nil
 }
 
 // Collect captures metrics on the host configure to this endpoint.
 func (exp *Expvar) Collect() (map[string]any, error) {
-	cxt := context.Background()
-	req, err := http.NewRequestWithContext(cxt, http.MethodGet, exp.host, nil)
+	return exp.fetch(context.Background(), exp.host)
+}
+
+// fetch captures the metrics served at url.
+func (exp *Expvar) fetch(ctx context.Context, url string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+// accept prefers OpenMetrics, which carries more type information, and falls
+// back to the Prometheus text format.
+const accept = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+
+// Prometheus provides the ability to receive metrics from services exposing
+// them in the Prometheus text or OpenMetrics format, like the /q/metrics
+// endpoint of Camel K integrations.
+type Prometheus struct {
+	host   string
+	client http.Client
+}
+
+// NewPrometheus creates a Prometheus for collecting metrics.
+func NewPrometheus(host string) (*Prometheus, error) {
+	return &Prometheus{
+		host:   host,
+		client: newClient(),
+	}, nil
+}
+
+// Collect captures metrics on the host configured to this endpoint. Every
+// metric is a key of the result, holding its samples as publisher.Point
+// values unless it is a single sample without labels.
+func (p *Prometheus) Collect() (map[string]any, error) {
+	return p.fetch(context.Background(), p.host)
+}
+
+// fetch captures the metrics served at url.
+func (p *Prometheus) fetch(ctx context.Context, url string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New(string(msg))
+	}
+
+	return parse(resp.Body)
+}
+
+// parse reads metrics in the Prometheus text or OpenMetrics format. The type
+// of the families is kept, histograms and summaries included, as well as
+// their help. Timestamps, exemplars and the creation time of counters are
+// dropped.
+func parse(r io.Reader) (map[string]any, error) {
+	out := make(map[string]any)
+	types := make(map[string]string)
+	helps := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "# EOF" {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			f := strings.SplitN(line, " ", 4)
+			switch {
+			case len(f) == 4 && f[1] == "TYPE":
+				types[f[2]] = strings.TrimSpace(f[3])
+			case len(f) == 4 && f[1] == "HELP":
+				helps[f[2]] = helpUnescaper.Replace(f[3])
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		family, kind, ok := sampleFamily(types, s.Path[0])
+		if !ok {
+			continue
+		}
+		s.Kind = kind
+		s.Help = helps[family]
+
+		publisher.Insert(out, s)
+	}
+
+	return out, scanner.Err()
+}
+
+var helpUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// suffixes are the suffixes of the samples of counter, histogram and
+// summary families.
+var suffixes = []string{"_total", "_created", "_bucket", "_count", "_sum", "_gcount", "_gsum"}
+
+// sampleFamily returns the family of the sample named name and its kind,
+// from the type of the family. It returns false for samples that are not
+// values, like the creation time of counters. Gauge histograms, which
+// cannot be published as such, are reported as gauges.
+func sampleFamily(types map[string]string, name string) (string, publisher.Kind, bool) {
+	family, suffix := name, ""
+	if _, ok := types[name]; !ok {
+		for _, s := range suffixes {
+			if f, found := strings.CutSuffix(name, s); found && types[f] != "" {
+				family, suffix = f, s
+				break
+			}
+		}
+	}
+
+	if suffix == "_created" {
+		return "", "", false
+	}
+
+	switch types[family] {
+	case "counter":
+		return family, publisher.KindCounter, true
+	case "histogram":
+		return family, publisher.KindHistogram, true
+	case "summary":
+		return family, publisher.KindSummary, true
+	default:
+		return family, publisher.KindGauge, true
+	}
+}
+
+func parseSample(line string) (publisher.Sample, error) {
+	var s publisher.Sample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.Path = []string{line[:end]}
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return s, err
+		}
+		s.Labels = labels
+		rest = rest[n:]
+	}
+
+	// drop the exemplar
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value of %s: %w", s.Path[0], err)
+	}
+	s.Value = value
+
+	return s, nil
+}
+
+// parseLabels parses the label set at the start of s, it returns the labels
+// and the length of the label set.
+func parseLabels(s string) ([]publisher.Label, int, error) {
+	var labels []publisher.Label
+
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return nil, 0, fmt.Errorf("invalid labels %q", s)
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return nil, 0, fmt.Errorf("unterminated label value in %q", s)
+			}
+
+			c := s[i]
+			i++
+			if c == '"' {
+				break
+			}
+			if c == '\\' && i < len(s) {
+				switch s[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = s[i]
+				}
+				i++
+			}
+			value.WriteByte(c)
+		}
+
+		labels = append(labels, publisher.Label{Name: name, Value: value.String()})
+	}
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/prometheus"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/camel.openmetrics")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	data, err := parse(f)
+	assert.NoError(t, err)
+
+	route := []publisher.Label{{Name: "camelContext", Value: "camel-1"}, {Name: "routeId", Value: "route1"}}
+
+	assert.Equal(t, map[string]any{
+		"CamelExchangesTotal_total": []publisher.Point{{
+			Labels: append(route[:2:2], publisher.Label{Name: "serviceName", Value: "MicrometerRoutePolicyService"}, publisher.Label{Name: "type", Value: "routes"}),
+			Help:   "Total number of processed exchanges",
+			Value:  42,
+		}},
+		"CamelExchangesInflight": []publisher.Point{{Labels: route, Value: 0}},
+		"CamelRoutePolicy_count": []publisher.Point{{Labels: route, Kind: publisher.KindSummary, Value: 42}},
+		"CamelRoutePolicy_sum":   []publisher.Point{{Labels: route, Kind: publisher.KindSummary, Value: 1.5}},
+		"CamelRoutePolicy": []publisher.Point{{
+			Labels: append(route[:2:2], publisher.Label{Name: "quantile", Value: "0.5"}),
+			Kind:   publisher.KindSummary,
+			Value:  0.035,
+		}},
+		"http_server_requests_seconds_bucket": []publisher.Point{
+			{Labels: []publisher.Label{{Name: "uri", Value: "/q/health"}, {Name: "le", Value: "0.1"}}, Kind: publisher.KindHistogram, Help: "Duration of the HTTP requests", Value: 3},
+			{Labels: []publisher.Label{{Name: "uri", Value: "/q/health"}, {Name: "le", Value: "+Inf"}}, Kind: publisher.KindHistogram, Help: "Duration of the HTTP requests", Value: 4},
+		},
+		"http_server_requests_seconds_count": []publisher.Point{{Labels: []publisher.Label{{Name: "uri", Value: "/q/health"}}, Kind: publisher.KindHistogram, Help: "Duration of the HTTP requests", Value: 4}},
+		"http_server_requests_seconds_sum":   []publisher.Point{{Labels: []publisher.Label{{Name: "uri", Value: "/q/health"}}, Kind: publisher.KindHistogram, Help: "Duration of the HTTP requests", Value: 0.25}},
+		"process_uptime_seconds":             120.5,
+		"jvm_info": []publisher.Point{{
+			Labels: []publisher.Label{{Name: "runtime", Value: `OpenJDK "Runtime"`}, {Name: "vendor", Value: `Red Hat\Inc`}},
+			Value:  1,
+		}},
+	}, data)
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	f, err := os.Open("testdata/camel.openmetrics")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	data, err := parse(f)
+	assert.NoError(t, err)
+
+	// the families are published with their type and help
+	var out bytes.Buffer
+	assert.NoError(t, prometheus.Write(&out, data, true))
+	assert.Contains(t, out.String(), `# HELP CamelExchangesTotal Total number of processed exchanges
+# TYPE CamelExchangesTotal counter
+CamelExchangesTotal_total{`)
+	assert.Contains(t, out.String(), `# HELP CamelRoutePolicy expvar CamelRoutePolicy
+# TYPE CamelRoutePolicy summary
+CamelRoutePolicy{camelContext="camel-1",routeId="route1",quantile="0.5"} 0.035
+CamelRoutePolicy_count{camelContext="camel-1",routeId="route1"} 42
+CamelRoutePolicy_sum{camelContext="camel-1",routeId="route1"} 1.5
+`)
+	assert.Contains(t, out.String(), `# HELP http_server_requests_seconds Duration of the HTTP requests
+# TYPE http_server_requests_seconds histogram
+http_server_requests_seconds_bucket{uri="/q/health",le="0.1"} 3
+http_server_requests_seconds_bucket{uri="/q/health",le="+Inf"} 4
+http_server_requests_seconds_count{uri="/q/health"} 4
+http_server_requests_seconds_sum{uri="/q/health"} 0.25
+`)
+	assert.NotContains(t, out.String(), "_count_total")
+}
+
+func TestParseRejectsInvalidSamples(t *testing.T) {
+	_, err := parse(strings.NewReader("up{job=\"a} 1\n"))
+	assert.EqualError(t, err, `line 1: unterminated label value in "{job=\"a} 1"`)
+}
@@ -0,0 +1,291 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+// Format is the format of the metrics served by a target.
+type Format string
+
+const (
+	// FormatExpvar is the JSON document served by expvar.
+	FormatExpvar Format = "expvar"
+	// FormatPrometheus is the Prometheus text or OpenMetrics format.
+	FormatPrometheus Format = "prometheus"
+)
+
+// The labels added to the metrics of every target.
+const (
+	LabelTarget   = "target"
+	LabelInstance = "instance"
+)
+
+// Target is an endpoint to collect metrics from.
+type Target struct {
+	// Name is the value of the target label of the metrics.
+	Name string
+	// URL is the address of the endpoint, its host is the value of the
+	// instance label of the metrics.
+	URL    string
+	Format Format
+}
+
+// Discoverer provides the targets to collect metrics from.
+type Discoverer interface {
+	Targets(ctx context.Context) ([]Target, error)
+}
+
+// =============================================================================
+
+// Static is a fixed list of targets.
+type Static []Target
+
+// ParseStatic parses targets serving format given as [name=]url, the name
+// defaults to static.
+func ParseStatic(entries []string, format Format) (Static, error) {
+	targets := make(Static, 0, len(entries))
+
+	for _, e := range entries {
+		t := Target{Name: "static", URL: e, Format: format}
+		if name, u, ok := strings.Cut(e, "="); ok && !strings.Contains(name, "/") {
+			t.Name, t.URL = name, u
+		}
+
+		if u, err := url.Parse(t.URL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid target %q: must be an absolute URL", e)
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// Targets returns the static targets.
+func (s Static) Targets(_ context.Context) ([]Target, error) {
+	return s, nil
+}
+
+// =============================================================================
+
+// PodsOptions configures the discovery of targets among Kubernetes pods.
+type PodsOptions struct {
+	// Name is the value of the target label of the pods.
+	Name      string
+	Namespace string
+	Selector  string
+	Port      int
+	Path      string
+	Format    Format
+}
+
+// Pods discovers the running pods matching a label selector.
+type Pods struct {
+	client kubernetes.Interface
+	opts   PodsOptions
+}
+
+// NewPods creates a Pods discovering targets with client.
+func NewPods(client kubernetes.Interface, opts PodsOptions) *Pods {
+	return &Pods{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// Targets lists the running pods matching the selector, those without an IP
+// yet are skipped.
+func (p *Pods) Targets(ctx context.Context) ([]Target, error) {
+	pods, err := p.client.CoreV1().Pods(p.opts.Namespace).List(ctx, metav1.ListOptions{LabelSelector: p.opts.Selector})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	targets := make([]Target, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+
+		targets = append(targets, Target{
+			Name:   p.opts.Name,
+			URL:    "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(p.opts.Port)) + p.opts.Path,
+			Format: p.opts.Format,
+		})
+	}
+
+	return targets, nil
+}
+
+// =============================================================================
+
+// Targets collects the metrics of several targets concurrently. The metrics
+// of every target are labelled with its target and instance, and the
+// success and duration of each scrape are reported as scrape.up and
+// scrape.duration_seconds.
+type Targets struct {
+	log         *slog.Logger
+	exp         *Expvar
+	prom        *Prometheus
+	timeout     time.Duration
+	discoverers []Discoverer
+}
+
+// NewTargets creates a Targets collecting from the targets of discoverers,
+// timeout bounds the discovery of the targets of each collection.
+func NewTargets(log *slog.Logger, timeout time.Duration, discoverers ...Discoverer) (*Targets, error) {
+	exp, err := New("")
+	if err != nil {
+		return nil, err
+	}
+
+	prom, err := NewPrometheus("")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Targets{
+		log:         log,
+		exp:         exp,
+		prom:        prom,
+		timeout:     timeout,
+		discoverers: discoverers,
+	}, nil
+}
+
+// Collect captures the metrics of every target. A target that cannot be
+// scraped is reported as down, only discovery failures are errors.
+func (t *Targets) Collect() (map[string]any, error) {
+	ctx := context.Background()
+
+	targets, err := t.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		data     map[string]any
+		err      error
+		duration time.Duration
+	}
+
+	results := make([]result, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			start := time.Now()
+			fetch := t.exp.fetch
+			if target.Format == FormatPrometheus {
+				fetch = t.prom.fetch
+			}
+
+			data, err := fetch(ctx, target.URL)
+			results[i] = result{data: data, err: err, duration: time.Since(start)}
+		}(i, target)
+	}
+	wg.Wait()
+
+	out := make(map[string]any)
+	for i, target := range targets {
+		labels := []publisher.Label{
+			{Name: LabelTarget, Value: target.Name},
+			{Name: LabelInstance, Value: instance(target.URL)},
+		}
+
+		up := 1.0
+		if err := results[i].err; err != nil {
+			t.log.Warn("collect", "status", "scrape failed", "target", target.Name, "url", target.URL, "msg", err)
+			up = 0
+		}
+
+		publisher.Insert(out, publisher.Sample{Path: []string{"scrape", "up"}, Labels: labels, Kind: publisher.KindGauge, Value: up})
+		publisher.Insert(out, publisher.Sample{Path: []string{"scrape", "duration_seconds"}, Labels: labels, Kind: publisher.KindGauge, Value: results[i].duration.Seconds()})
+
+		publisher.Walk(results[i].data, func(s publisher.Sample) {
+			s.Labels = withTarget(labels, s.Labels)
+			publisher.Insert(out, s)
+		})
+	}
+
+	return out, nil
+}
+
+// withTarget prepends the target labels to the scraped labels. A scraped
+// label named like a target label is renamed exported_<name>, as Prometheus
+// does, prefixed again while the name is taken.
+func withTarget(target, scraped []publisher.Label) []publisher.Label {
+	out := append(target[:len(target):len(target)], scraped...)
+
+	taken := make(map[string]bool, len(out))
+	for _, l := range out {
+		taken[l.Name] = true
+	}
+
+	for i := len(target); i < len(out); i++ {
+		if !isTargetLabel(target, out[i].Name) {
+			continue
+		}
+
+		name := "exported_" + out[i].Name
+		for taken[name] {
+			name = "exported_" + name
+		}
+		taken[name] = true
+		out[i].Name = name
+	}
+
+	return out
+}
+
+func isTargetLabel(target []publisher.Label, name string) bool {
+	for _, l := range target {
+		if l.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Targets) discover(ctx context.Context) ([]Target, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var targets []Target
+	for _, d := range t.discoverers {
+		found, err := d.Targets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, found...)
+	}
+
+	return targets, nil
+}
+
+func instance(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	return u.Host
+}
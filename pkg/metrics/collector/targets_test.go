@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+func TestTargetsCollect(t *testing.T) {
+	logger.Init(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"goroutines": 12}`))
+	}))
+	defer srv.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	static, err := ParseStatic([]string{"backend=" + srv.URL, down.URL}, FormatExpvar)
+	assert.NoError(t, err)
+
+	c, err := NewTargets(logger.L, time.Second, static)
+	assert.NoError(t, err)
+
+	data, err := c.Collect()
+	assert.NoError(t, err)
+
+	up := strings.TrimPrefix(srv.URL, "http://")
+	gone := strings.TrimPrefix(down.URL, "http://")
+
+	assert.Equal(t, []publisher.Point{
+		{Labels: []publisher.Label{{Name: "target", Value: "backend"}, {Name: "instance", Value: up}}, Value: 12},
+	}, data["goroutines"])
+
+	scrape := data["scrape"].(map[string]any)
+	assert.Equal(t, []publisher.Point{
+		{Labels: []publisher.Label{{Name: "target", Value: "backend"}, {Name: "instance", Value: up}}, Value: 1},
+		{Labels: []publisher.Label{{Name: "target", Value: "static"}, {Name: "instance", Value: gone}}, Value: 0},
+	}, scrape["up"])
+	assert.Len(t, scrape["duration_seconds"], 2)
+}
+
+func TestTargetsCollectRenamesClashingLabels(t *testing.T) {
+	logger.Init(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE requests counter\nrequests_total{instance=\"pod-1\",exported_instance=\"a\",target=\"b\"} 3\n"))
+	}))
+	defer srv.Close()
+
+	static, err := ParseStatic([]string{"app=" + srv.URL}, FormatPrometheus)
+	assert.NoError(t, err)
+
+	c, err := NewTargets(logger.L, time.Second, static)
+	assert.NoError(t, err)
+
+	data, err := c.Collect()
+	assert.NoError(t, err)
+
+	// the scraped labels never shadow the target labels
+	assert.Equal(t, []publisher.Point{{Labels: []publisher.Label{
+		{Name: "target", Value: "app"},
+		{Name: "instance", Value: strings.TrimPrefix(srv.URL, "http://")},
+		{Name: "exported_exported_instance", Value: "pod-1"},
+		{Name: "exported_instance", Value: "a"},
+		{Name: "exported_target", Value: "b"},
+	}, Value: 3}}, data["requests_total"])
+}
+
+// hanging is a discoverer that never answers.
+type hanging struct{}
+
+func (hanging) Targets(ctx context.Context) ([]Target, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTargetsDiscoveryTimeout(t *testing.T) {
+	logger.Init(true)
+
+	c, err := NewTargets(logger.L, 10*time.Millisecond, hanging{})
+	assert.NoError(t, err)
+
+	_, err = c.Collect()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestParseStaticRejectsRelativeURLs(t *testing.T) {
+	_, err := ParseStatic([]string{"localhost:8083"}, FormatExpvar)
+	assert.EqualError(t, err, `invalid target "localhost:8083": must be an absolute URL`)
+}
+
+func TestPodsTargets(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase, ip string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "sco", Labels: map[string]string{"app": "sco-backend"}},
+			Status:     corev1.PodStatus{Phase: phase, PodIP: ip},
+		}
+	}
+
+	cl := fake.NewSimpleClientset(
+		pod("running", corev1.PodRunning, "10.0.0.1"),
+		pod("pending", corev1.PodPending, ""),
+	)
+
+	p := NewPods(cl, PodsOptions{Name: "sco-backend", Namespace: "sco", Selector: "app=sco-backend", Port: 8083, Path: "/debug/vars", Format: FormatExpvar})
+
+	targets, err := p.Targets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Target{{Name: "sco-backend", URL: "http://10.0.0.1:8083/debug/vars", Format: FormatExpvar}}, targets)
+}
@@ -65,7 +65,7 @@ func (exp *Expvar) Stop(shutdownTimeout time.Duration) {
 }
 
 // Publish is called by the publisher goroutine and saves the raw stats.
-func (exp *Expvar) Publish(data map[string]any) error {
+func (exp *Expvar) Publish(_ context.Context, data map[string]any) error {
 	exp.mu.Lock()
 	defer exp.mu.Unlock()
 
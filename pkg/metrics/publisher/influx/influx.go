@@ -0,0 +1,253 @@
+// Package influx manages the publishing of metrics to InfluxDB with the line
+// protocol over HTTP.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+	"github.com/sco1237896/sco-backend/pkg/metrics/rules"
+)
+
+type Options struct {
+	// URL is the write endpoint, with its query, such as
+	// http://influxdb:8086/api/v2/write?org=sco&bucket=metrics.
+	URL string
+	// Token is sent in the Authorization header if set.
+	Token    logger.Secret
+	Timeout  time.Duration
+	Queue    publisher.QueueOptions
+	Mappings []Mapping
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout: 10 * time.Second,
+		Queue:   publisher.DefaultQueueOptions(),
+	}
+}
+
+func (o Options) Validate() error {
+	if o.URL == "" {
+		return errors.New("the InfluxDB URL is required")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("the InfluxDB timeout must be positive, got %s", o.Timeout)
+	}
+	for i, m := range o.Mappings {
+		if m.Match == "" || m.Measurement == "" {
+			return fmt.Errorf("InfluxDB mapping %d: match and measurement are required", i+1)
+		}
+	}
+
+	return o.Queue.Validate()
+}
+
+// Mapping maps the metrics matching a glob, with the syntax of the rules, to
+// a measurement, a field and tags. Measurement, Field and the values of Tags
+// can reference the segments of the metric name as $1, $2 and so on.
+type Mapping struct {
+	Match       string            `json:"match"`
+	Measurement string            `json:"measurement"`
+	Field       string            `json:"field,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// LoadMappings reads mappings from a YAML or JSON file.
+func LoadMappings(file string) ([]Mapping, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading InfluxDB mappings: %w", err)
+	}
+
+	var m struct {
+		Mappings []Mapping `json:"mappings"`
+	}
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("parsing InfluxDB mappings %s: %w", file, err)
+	}
+
+	return m.Mappings, nil
+}
+
+// Influx writes the collected metrics as points of the line protocol.
+type Influx struct {
+	log    *slog.Logger
+	opts   Options
+	client http.Client
+	queue  *publisher.Queue[string]
+}
+
+// New creates an Influx publisher.
+func New(opts Options, log *slog.Logger) *Influx {
+	i := Influx{
+		log:    log,
+		opts:   opts,
+		client: http.Client{Timeout: opts.Timeout},
+	}
+	i.queue = publisher.NewQueue(log, opts.Queue, i.send)
+
+	return &i
+}
+
+// Publish is called by the publisher goroutine and writes the metrics, with
+// those left from previous failures.
+func (i *Influx) Publish(ctx context.Context, data map[string]any) error {
+	return i.queue.Push(ctx, Lines(data, i.opts.Mappings, time.Now()))
+}
+
+func (i *Influx) send(ctx context.Context, lines []string) error {
+	body := strings.Join(lines, "\n")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.opts.URL, strings.NewReader(body))
+	if err != nil {
+		return publisher.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token := i.opts.Token.Value(); token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return publisher.CheckResponse(resp)
+}
+
+// =============================================================================
+
+// point is a line being built, key holds its measurement and tags.
+type point struct {
+	key    string
+	fields []publisher.Label
+}
+
+// Lines renders data as lines of the line protocol at time now. Metrics
+// sharing a measurement and tags are written as fields of the same point.
+// Without a matching mapping, the last segment of the name is the field and
+// the others the measurement, and labels are tags.
+func Lines(data map[string]any, mappings []Mapping, now time.Time) []string {
+	points := make(map[string]*point)
+	var keys []string
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		if math.IsInf(s.Value, 0) || math.IsNaN(s.Value) {
+			return
+		}
+
+		measurement, field, tags := mapSample(s, mappings)
+		sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+		var key strings.Builder
+		key.WriteString(escape(measurement, measurementEscaper))
+		for _, t := range tags {
+			// the line protocol has no empty tag values
+			if t.Value == "" {
+				continue
+			}
+			key.WriteString("," + escape(t.Name, keyEscaper) + "=" + escape(t.Value, keyEscaper))
+		}
+
+		p, ok := points[key.String()]
+		if !ok {
+			p = &point{key: key.String()}
+			points[key.String()] = p
+			keys = append(keys, key.String())
+		}
+		p.fields = append(p.fields, publisher.Label{Name: field, Value: strconv.FormatFloat(s.Value, 'f', -1, 64)})
+	})
+
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	lines := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+		p := points[k]
+
+		var b bytes.Buffer
+		b.WriteString(p.key)
+		for i, f := range p.fields {
+			if i == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(escape(f.Name, keyEscaper) + "=" + f.Value)
+		}
+		b.WriteString(" " + ts)
+
+		lines = append(lines, b.String())
+	}
+
+	return lines
+}
+
+var segmentRef = regexp.MustCompile(`\$(\d+)`)
+
+func mapSample(s publisher.Sample, mappings []Mapping) (string, string, []publisher.Label) {
+	tags := append([]publisher.Label(nil), s.Labels...)
+
+	expand := func(template string) string {
+		return segmentRef.ReplaceAllStringFunc(template, func(ref string) string {
+			n, _ := strconv.Atoi(ref[1:])
+			if n < 1 || n > len(s.Path) {
+				return ""
+			}
+			return s.Path[n-1]
+		})
+	}
+
+	for _, m := range mappings {
+		if !rules.Glob(m.Match, s.Path) {
+			continue
+		}
+
+		field := "value"
+		if m.Field != "" {
+			field = expand(m.Field)
+		}
+
+		names := make([]string, 0, len(m.Tags))
+		for name := range m.Tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tags = append(tags, publisher.Label{Name: name, Value: expand(m.Tags[name])})
+		}
+
+		return expand(m.Measurement), field, tags
+	}
+
+	if len(s.Path) == 1 {
+		return s.Path[0], "value", tags
+	}
+
+	return strings.Join(s.Path[:len(s.Path)-1], "."), s.Path[len(s.Path)-1], tags
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func escape(s string, r *strings.Replacer) string {
+	return r.Replace(s)
+}
@@ -0,0 +1,81 @@
+package influx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+var data = map[string]any{
+	"goroutines": 12.0,
+	"memstats":   map[string]any{"Alloc": 1024.0, "NumGC": 3.0},
+	"health": map[string]any{
+		"readiness": map[string]any{"camel k": true},
+	},
+	"pipes": []publisher.Point{
+		{Labels: []publisher.Label{{Name: "namespace", Value: "sco"}, {Name: "empty", Value: ""}}, Value: 2},
+	},
+}
+
+var mappings = []Mapping{
+	{Match: "health.*.*", Measurement: "health", Field: "up", Tags: map[string]string{"kind": "$2", "check": "$3"}},
+}
+
+func TestLines(t *testing.T) {
+	now := time.Unix(1, 0)
+
+	assert.Equal(t, []string{
+		"goroutines value=12 1000000000",
+		`health,check=camel\ k,kind=readiness up=1 1000000000`,
+		"memstats Alloc=1024,NumGC=3 1000000000",
+		"pipes,namespace=sco value=2 1000000000",
+	}, Lines(data, mappings, now))
+}
+
+func TestPublish(t *testing.T) {
+	logger.Init(true)
+
+	var bodies []string
+	status := http.StatusServiceUnavailable
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+
+		b, _ := io.ReadAll(r.Body)
+		if status == http.StatusNoContent {
+			bodies = append(bodies, string(b))
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.URL = srv.URL + "/api/v2/write?org=sco&bucket=metrics"
+	opts.Token = "secret"
+	opts.Queue.BatchSize = 3
+	opts.Mappings = mappings
+	assert.NoError(t, opts.Validate())
+
+	i := New(opts, logger.L)
+
+	// the lines are kept while the receiver is unavailable
+	assert.Error(t, i.Publish(context.Background(), data))
+	assert.Equal(t, 4, i.queue.Pending())
+
+	status = http.StatusNoContent
+	assert.NoError(t, i.Publish(context.Background(), data))
+	assert.Equal(t, 0, i.queue.Pending())
+
+	// 8 lines in batches of 3
+	assert.Len(t, bodies, 3)
+	assert.Equal(t, 8, strings.Count(strings.Join(bodies, "\n"), "\n")+1)
+}
@@ -0,0 +1,282 @@
+// Package otlp manages the publishing of metrics to an OpenTelemetry
+// collector over OTLP.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+
+	// ServiceName is the default service.name of the published metrics.
+	ServiceName = "sco-backend"
+)
+
+// scope is the instrumentation scope of the published metrics.
+var scope = instrumentation.Scope{Name: "github.com/sco1237896/sco-backend/pkg/metrics/publisher/otlp"}
+
+// RetryOptions configures the retries of failed exports, with an
+// exponential backoff. The retries are bounded by the timeout of the export,
+// so MaxElapsedTime must not exceed it.
+type RetryOptions struct {
+	Enabled         bool
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+type Options struct {
+	Protocol string
+	// Endpoint is the host and port of the collector, the default of the
+	// protocol is used if empty.
+	Endpoint    string
+	Insecure    bool
+	Headers     map[string]string
+	Compression string
+	Timeout     time.Duration
+	Retry       RetryOptions
+	// ResourceAttributes are added to, or override, the attributes of the
+	// default resource.
+	ResourceAttributes map[string]string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Protocol:    ProtocolGRPC,
+		Compression: CompressionGzip,
+		Timeout:     10 * time.Second,
+		Retry: RetryOptions{
+			Enabled:         true,
+			InitialInterval: time.Second,
+			MaxInterval:     5 * time.Second,
+			MaxElapsedTime:  10 * time.Second,
+		},
+	}
+}
+
+func (o Options) Validate() error {
+	switch o.Protocol {
+	case ProtocolGRPC, ProtocolHTTP:
+	default:
+		return fmt.Errorf("unknown OTLP protocol %q, must be %s or %s", o.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+	switch o.Compression {
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unknown OTLP compression %q, must be %s or %s", o.Compression, CompressionNone, CompressionGzip)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("the OTLP timeout must be positive, got %s", o.Timeout)
+	}
+	if o.Retry.Enabled && (o.Retry.InitialInterval <= 0 || o.Retry.MaxInterval < o.Retry.InitialInterval) {
+		return fmt.Errorf("the OTLP retry intervals must be positive and increasing, got %s and %s", o.Retry.InitialInterval, o.Retry.MaxInterval)
+	}
+	if o.Retry.Enabled && o.Retry.MaxElapsedTime > o.Timeout {
+		return fmt.Errorf("the OTLP retries are bounded by the timeout %s, the maximum elapsed time %s cannot be reached", o.Timeout, o.Retry.MaxElapsedTime)
+	}
+
+	return nil
+}
+
+// OTLP converts the collected metrics to OpenTelemetry metrics and pushes
+// them to a collector.
+type OTLP struct {
+	log      *slog.Logger
+	exporter metric.Exporter
+	resource *resource.Resource
+	timeout  time.Duration
+
+	mu sync.Mutex
+	// last is when the metrics were last converted, the start of the
+	// counters that appear or are reset afterwards
+	last     time.Time
+	counters map[string]counter
+}
+
+// counter is the last value of a cumulative sum and the start of its
+// accumulation.
+type counter struct {
+	start time.Time
+	value float64
+}
+
+// New creates an OTLP publisher, version is the service.version of the
+// published metrics.
+func New(ctx context.Context, opts Options, version string, log *slog.Logger) (*OTLP, error) {
+	exporter, err := newExporter(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName(ServiceName),
+		semconv.ServiceVersion(version),
+	}
+	for k, v := range opts.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP resource: %w", err)
+	}
+
+	return &OTLP{
+		log:      log,
+		exporter: exporter,
+		resource: res,
+		timeout:  opts.Timeout,
+		last:     time.Now(),
+		counters: make(map[string]counter),
+	}, nil
+}
+
+func newExporter(ctx context.Context, opts Options) (metric.Exporter, error) {
+	switch opts.Protocol {
+	case ProtocolHTTP:
+		o := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithTimeout(opts.Timeout),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig(opts.Retry)),
+		}
+		if opts.Endpoint != "" {
+			o = append(o, otlpmetrichttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			o = append(o, otlpmetrichttp.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			o = append(o, otlpmetrichttp.WithHeaders(opts.Headers))
+		}
+		if opts.Compression == CompressionGzip {
+			o = append(o, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+		return otlpmetrichttp.New(ctx, o...)
+	default:
+		o := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithTimeout(opts.Timeout),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig(opts.Retry)),
+		}
+		if opts.Endpoint != "" {
+			o = append(o, otlpmetricgrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			o = append(o, otlpmetricgrpc.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			o = append(o, otlpmetricgrpc.WithHeaders(opts.Headers))
+		}
+		if opts.Compression == CompressionGzip {
+			o = append(o, otlpmetricgrpc.WithCompressor(CompressionGzip))
+		}
+		return otlpmetricgrpc.New(ctx, o...)
+	}
+}
+
+// Publish is called by the publisher goroutine and pushes the metrics.
+func (o *OTLP) Publish(ctx context.Context, data map[string]any) error {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	rm := o.convert(data, time.Now())
+
+	return o.exporter.Export(ctx, &rm)
+}
+
+// Stop flushes and shuts down the exporter.
+func (o *OTLP) Stop(ctx context.Context) error {
+	o.log.InfoContext(ctx, "otlp", "status", "start shutdown...")
+	defer o.log.InfoContext(ctx, "otlp: Completed")
+
+	return o.exporter.Shutdown(ctx)
+}
+
+// convert groups the samples of data into metrics named by their dotted
+// path. Counters become cumulative monotonic sums, other values gauges. A
+// counter lower than its previous value, like the counter of a restarted
+// target, starts a new accumulation.
+func (o *OTLP) convert(data map[string]any, now time.Time) metricdata.ResourceMetrics {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var metrics []metricdata.Metrics
+	index := make(map[string]int)
+	counters := make(map[string]counter, len(o.counters))
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		name := strings.Join(s.Path, ".")
+
+		i, ok := index[name]
+		if !ok {
+			i = len(metrics)
+			index[name] = i
+
+			m := metricdata.Metrics{Name: name}
+			if s.Kind == publisher.KindCounter {
+				m.Data = metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			} else {
+				m.Data = metricdata.Gauge[float64]{}
+			}
+			metrics = append(metrics, m)
+		}
+
+		attrs := make([]attribute.KeyValue, 0, len(s.Labels))
+		for _, l := range s.Labels {
+			attrs = append(attrs, attribute.String(l.Name, l.Value))
+		}
+
+		point := metricdata.DataPoint[float64]{
+			Attributes: attribute.NewSet(attrs...),
+			StartTime:  o.last,
+			Time:       now,
+			Value:      s.Value,
+		}
+
+		if s.Kind == publisher.KindCounter {
+			key := name + "\x00" + point.Attributes.Encoded(attribute.DefaultEncoder())
+			if previous, ok := o.counters[key]; ok && s.Value >= previous.value {
+				point.StartTime = previous.start
+			}
+			counters[key] = counter{start: point.StartTime, value: s.Value}
+		}
+
+		switch d := metrics[i].Data.(type) {
+		case metricdata.Sum[float64]:
+			d.DataPoints = append(d.DataPoints, point)
+			metrics[i].Data = d
+		case metricdata.Gauge[float64]:
+			d.DataPoints = append(d.DataPoints, point)
+			metrics[i].Data = d
+		}
+	})
+
+	o.last, o.counters = now, counters
+
+	return metricdata.ResourceMetrics{
+		Resource: o.resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   scope,
+			Metrics: metrics,
+		}},
+	}
+}
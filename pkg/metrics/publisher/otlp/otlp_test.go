@@ -0,0 +1,193 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+var data = map[string]any{
+	"goroutines": 12.0,
+	"memstats":   map[string]any{"NumGC": publisher.Point{Kind: publisher.KindCounter, Value: 3}},
+	"health": []publisher.Point{
+		{Labels: []publisher.Label{{Name: "check", Value: "camel-k"}}, Value: 1},
+	},
+}
+
+// receiver is an in-process stand-in for an OTLP collector.
+type receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	requests []*collectorpb.ExportMetricsServiceRequest
+	headers  []string
+}
+
+func (r *receiver) Export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.requests = append(r.requests, req)
+	r.headers = append(r.headers, md.Get("x-tenant")...)
+
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body := io.Reader(req.Body)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg := collectorpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, &msg)
+	r.headers = append(r.headers, req.Header.Get("X-Tenant"))
+
+	out, _ := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(out)
+}
+
+func TestPublishHTTP(t *testing.T) {
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.Protocol = ProtocolHTTP
+	opts.Endpoint = strings.TrimPrefix(srv.URL, "http://")
+	opts.Insecure = true
+	opts.Headers = map[string]string{"X-Tenant": "sco"}
+	opts.ResourceAttributes = map[string]string{"deployment.environment": "test"}
+
+	publish(t, opts)
+	assertReceived(t, r)
+}
+
+func TestPublishGRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	r := &receiver{}
+	srv := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(srv, r)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	opts := DefaultOptions()
+	opts.Endpoint = l.Addr().String()
+	opts.Insecure = true
+	opts.Headers = map[string]string{"x-tenant": "sco"}
+	opts.ResourceAttributes = map[string]string{"deployment.environment": "test"}
+
+	publish(t, opts)
+	assertReceived(t, r)
+}
+
+func publish(t *testing.T, opts Options) {
+	t.Helper()
+	logger.Init(true)
+
+	assert.NoError(t, opts.Validate())
+
+	o, err := New(context.Background(), opts, "test", logger.L)
+	assert.NoError(t, err)
+
+	assert.NoError(t, o.Publish(context.Background(), data))
+	assert.NoError(t, o.Stop(context.Background()))
+}
+
+func assertReceived(t *testing.T, r *receiver) {
+	t.Helper()
+
+	assert.Len(t, r.requests, 1)
+	assert.Equal(t, []string{"sco"}, r.headers)
+
+	rm := r.requests[0].ResourceMetrics[0]
+
+	resource := make(map[string]string)
+	for _, kv := range rm.Resource.Attributes {
+		resource[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, "sco-backend", resource["service.name"])
+	assert.Equal(t, "test", resource["service.version"])
+	assert.Equal(t, "test", resource["deployment.environment"])
+
+	metrics := make(map[string]*metricspb.Metric)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	assert.Len(t, metrics, 3)
+
+	assert.Equal(t, 12.0, metrics["goroutines"].GetGauge().DataPoints[0].GetAsDouble())
+
+	gc := metrics["memstats.NumGC"].GetSum()
+	assert.True(t, gc.IsMonotonic)
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, gc.AggregationTemporality)
+	assert.Equal(t, 3.0, gc.DataPoints[0].GetAsDouble())
+
+	health := metrics["health"].GetGauge().DataPoints[0]
+	assert.Equal(t, "check", health.Attributes[0].Key)
+	assert.Equal(t, "camel-k", health.Attributes[0].Value.GetStringValue())
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Protocol = "thrift"
+	assert.EqualError(t, opts.Validate(), `unknown OTLP protocol "thrift", must be grpc or http`)
+}
+
+func TestOptionsValidateRetryBound(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Retry.MaxElapsedTime = time.Minute
+	assert.EqualError(t, opts.Validate(), "the OTLP retries are bounded by the timeout 10s, the maximum elapsed time 1m0s cannot be reached")
+
+	opts.Retry.Enabled = false
+	assert.NoError(t, opts.Validate())
+}
+
+func TestConvertResetsStartTime(t *testing.T) {
+	start := time.Unix(0, 0)
+	o := &OTLP{last: start, counters: make(map[string]counter)}
+
+	convert := func(gc float64, now time.Time) time.Time {
+		data := map[string]any{"memstats": map[string]any{"NumGC": publisher.Point{Kind: publisher.KindCounter, Value: gc}}}
+		rm := o.convert(data, now)
+		return rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[float64]).DataPoints[0].StartTime
+	}
+
+	assert.Equal(t, start, convert(3, start.Add(time.Minute)))
+	assert.Equal(t, start, convert(5, start.Add(2*time.Minute)))
+
+	// a restarted target starts counting again after the previous export
+	assert.Equal(t, start.Add(2*time.Minute), convert(1, start.Add(3*time.Minute)))
+	assert.Equal(t, start.Add(2*time.Minute), convert(2, start.Add(4*time.Minute)))
+}
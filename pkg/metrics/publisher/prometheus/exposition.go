@@ -0,0 +1,270 @@
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+const (
+	// ContentTypeText is the media type of the Prometheus text format.
+	ContentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+	// ContentTypeOpenMetrics is the media type of the OpenMetrics text format.
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// family is the set of samples sharing a metric name, or, for histograms and
+// summaries, the name their samples are suffixed from. Its help is the one
+// collected with the metric, or else its expvar name.
+type family struct {
+	name    string
+	help    string
+	kind    publisher.Kind
+	samples []sample
+}
+
+type sample struct {
+	name string
+	publisher.Sample
+}
+
+// suffixes are the suffixes of the sample names of histograms and summaries,
+// in exposition order.
+var suffixes = map[publisher.Kind][]string{
+	publisher.KindHistogram: {"_bucket", "_count", "_sum"},
+	publisher.KindSummary:   {"", "_count", "_sum"},
+}
+
+// families groups the samples of data by sanitized metric name. Samples that
+// end up with the name and labels of an earlier sample are dropped, as they
+// would make the exposition invalid.
+func families(data map[string]any) []*family {
+	byName := make(map[string]*family)
+	seen := make(map[string]bool)
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		name := MetricName(s.Path)
+		familyName := familyOf(name, s.Kind)
+
+		f, ok := byName[familyName]
+		if !ok {
+			help := s.Help
+			if help == "" {
+				help = "expvar " + familyOf(strings.Join(s.Path, "."), s.Kind)
+			}
+
+			f = &family{
+				name: familyName,
+				help: help,
+				kind: s.Kind,
+			}
+			byName[familyName] = f
+		}
+
+		key := name + labels(s.Labels)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		f.samples = append(f.samples, sample{name: name, Sample: s})
+	})
+
+	out := make([]*family, 0, len(byName))
+	for _, f := range byName {
+		if _, ok := suffixes[f.kind]; ok {
+			sortPoints(f)
+		}
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+
+	return out
+}
+
+// familyOf returns the name of the histogram or summary of a sample, its
+// name without the suffix of a bucket, a sum or a count, which may also be
+// the last segment of a dotted name.
+func familyOf(name string, kind publisher.Kind) string {
+	for _, suffix := range suffixes[kind] {
+		if suffix == "" {
+			continue
+		}
+		for _, sep := range []string{"_", "."} {
+			if f, ok := strings.CutSuffix(name, sep+suffix[1:]); ok {
+				return f
+			}
+		}
+	}
+
+	return name
+}
+
+// sortPoints groups the samples of a histogram or summary family by labels,
+// and orders each group by suffix, then by bucket bound or quantile.
+func sortPoints(f *family) {
+	rank := make(map[string]int)
+	for i, suffix := range suffixes[f.kind] {
+		rank[f.name+suffix] = i
+	}
+
+	sort.SliceStable(f.samples, func(i, j int) bool {
+		a, b := f.samples[i], f.samples[j]
+		if la, lb := labels(withoutBound(a.Labels)), labels(withoutBound(b.Labels)); la != lb {
+			return la < lb
+		}
+		if rank[a.name] != rank[b.name] {
+			return rank[a.name] < rank[b.name]
+		}
+
+		return bound(a.Labels) < bound(b.Labels)
+	})
+}
+
+// isBound reports whether l is the bucket bound of a histogram sample or the
+// quantile of a summary sample.
+func isBound(l publisher.Label) bool {
+	return l.Name == "le" || l.Name == "quantile"
+}
+
+func withoutBound(ls []publisher.Label) []publisher.Label {
+	out := make([]publisher.Label, 0, len(ls))
+	for _, l := range ls {
+		if !isBound(l) {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
+
+func bound(ls []publisher.Label) float64 {
+	for _, l := range ls {
+		if isBound(l) {
+			v, err := strconv.ParseFloat(l.Value, 64)
+			if err != nil {
+				return math.Inf(1)
+			}
+			return v
+		}
+	}
+
+	return 0
+}
+
+// Write renders data in the Prometheus text format, or in the OpenMetrics
+// text format if openMetrics is set.
+func Write(w io.Writer, data map[string]any, openMetrics bool) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range families(data) {
+		name := f.name
+
+		// OpenMetrics counter families have no _total suffix, which their samples have
+		counter := openMetrics && f.kind == publisher.KindCounter
+		if counter {
+			name = strings.TrimSuffix(f.name, "_total")
+		}
+
+		bw.WriteString("# HELP " + name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + name + " " + string(f.kind) + "\n")
+
+		for _, s := range f.samples {
+			sampleName := s.name
+			if counter {
+				sampleName = name + "_total"
+			}
+			bw.WriteString(sampleName + labels(s.Labels) + " " + formatValue(s.Value) + "\n")
+		}
+	}
+
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+
+	return bw.Flush()
+}
+
+// MetricName joins path into a valid metric name: characters other than
+// letters, digits and underscores are replaced with underscores, and names
+// starting with a digit are prefixed with one.
+func MetricName(path []string) string {
+	var b strings.Builder
+
+	for i, p := range path {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		for _, r := range p {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				b.WriteRune(r)
+			default:
+				b.WriteByte('_')
+			}
+		}
+	}
+
+	name := b.String()
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+func labels(ls []publisher.Label) string {
+	if len(ls) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range ls {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(MetricName([]string{l.Name}))
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// formatValue renders integral values without exponent, so counters and
+// byte sizes stay readable, and other values with full precision.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
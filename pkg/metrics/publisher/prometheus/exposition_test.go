@@ -0,0 +1,98 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestWrite(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "expvar.json"))
+	assert.NoError(t, err)
+
+	data := make(map[string]any)
+	assert.NoError(t, json.Unmarshal(raw, &data))
+
+	for _, tc := range []struct {
+		golden      string
+		openMetrics bool
+	}{
+		{"text.golden", false},
+		{"openmetrics.golden", true},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.NoError(t, Write(&out, data, tc.openMetrics))
+
+			golden := filepath.Join("testdata", tc.golden)
+			if *update {
+				assert.NoError(t, os.WriteFile(golden, out.Bytes(), 0o600))
+			}
+
+			expected, err := os.ReadFile(golden)
+			assert.NoError(t, err)
+			assert.Equal(t, string(expected), out.String())
+		})
+	}
+}
+
+func TestWriteHistogram(t *testing.T) {
+	check := func(name, le string) []publisher.Label {
+		ls := []publisher.Label{{Name: "check", Value: name}}
+		if le != "" {
+			ls = append(ls, publisher.Label{Name: "le", Value: le})
+		}
+		return ls
+	}
+
+	data := map[string]any{
+		"health": map[string]any{
+			"duration": map[string]any{
+				"bucket": []publisher.Point{
+					{Labels: check("b", "+Inf"), Kind: publisher.KindHistogram, Value: 1},
+					{Labels: check("b", "0.5"), Kind: publisher.KindHistogram, Value: 1},
+					{Labels: check("a", "+Inf"), Kind: publisher.KindHistogram, Value: 2},
+					{Labels: check("a", "0.5"), Kind: publisher.KindHistogram, Value: 1},
+				},
+				"count": []publisher.Point{
+					{Labels: check("a", ""), Kind: publisher.KindHistogram, Value: 2},
+					{Labels: check("b", ""), Kind: publisher.KindHistogram, Value: 1},
+				},
+				"sum": []publisher.Point{
+					{Labels: check("a", ""), Kind: publisher.KindHistogram, Value: 0.75},
+					{Labels: check("b", ""), Kind: publisher.KindHistogram, Value: 0.25},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, Write(&out, data, true))
+	assert.Equal(t, `# HELP health_duration expvar health.duration
+# TYPE health_duration histogram
+health_duration_bucket{check="a",le="0.5"} 1
+health_duration_bucket{check="a",le="+Inf"} 2
+health_duration_count{check="a"} 2
+health_duration_sum{check="a"} 0.75
+health_duration_bucket{check="b",le="0.5"} 1
+health_duration_bucket{check="b",le="+Inf"} 1
+health_duration_count{check="b"} 1
+health_duration_sum{check="b"} 0.25
+# EOF
+`, out.String())
+}
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	assert.True(t, acceptsOpenMetrics("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"))
+	assert.False(t, acceptsOpenMetrics("text/plain;version=0.0.4"))
+	assert.False(t, acceptsOpenMetrics(""))
+}
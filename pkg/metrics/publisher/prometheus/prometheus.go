@@ -4,9 +4,9 @@ package prometheus
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -48,7 +48,7 @@ func New(log *slog.Logger, host string, route string, readTimeout, writeTimeout
 }
 
 // Publish stores a deep copy of the data for publishing.
-func (exp *Exporter) Publish(data map[string]any) error {
+func (exp *Exporter) Publish(_ context.Context, data map[string]any) error {
 	exp.mu.Lock()
 	defer exp.mu.Unlock()
 
@@ -77,67 +77,70 @@ func (exp *Exporter) Stop(shutdownTimeout time.Duration) {
 // =============================================================================
 
 func (exp *Exporter) handler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	w.WriteHeader(http.StatusOK)
+	openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+
+	contentType := ContentTypeText
+	if openMetrics {
+		contentType = ContentTypeOpenMetrics
+	}
 
 	var data map[string]any
-	exp.mu.Lock()
+	exp.mu.RLock()
 	{
-		data = deepCopyMap(exp.data)
+		data = exp.data
 	}
-	exp.mu.Unlock()
+	exp.mu.RUnlock()
 
-	out(w, "", data)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	if err := Write(w, data, openMetrics); err != nil {
+		exp.log.Error("prometheus", "status", "could not write metrics", "msg", err)
+	}
 
 	exp.log.Info("prometheus", "metrics", fmt.Sprintf("expvar : (%d) : %s %s -> %s", http.StatusOK, r.Method, r.URL.Path, r.RemoteAddr))
 }
 
+// acceptsOpenMetrics reports whether the Accept header of a scrape asks for
+// OpenMetrics, which Prometheus does when it supports it.
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) == "application/openmetrics-text" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // =============================================================================
 
+// deepCopyMap copies the maps and arrays of source, so the published data
+// cannot change while it is being written.
 func deepCopyMap(source map[string]any) map[string]any {
-	result := make(map[string]any)
+	result := make(map[string]any, len(source))
 
 	for k, v := range source {
-		switch vm := v.(type) {
-		case map[string]any:
-			result[k] = deepCopyMap(vm)
-
-		case int64:
-			result[k] = float64(vm)
-
-		case float64:
-			result[k] = vm
-
-		case bool:
-			result[k] = 0.0
-			if vm {
-				result[k] = 1.0
-			}
-		default:
-			// do nothing
-		}
+		result[k] = deepCopy(v)
 	}
 
 	return result
 }
 
-func out(w io.Writer, prefix string, data map[string]any) {
-	if prefix != "" {
-		prefix += "_"
-	}
+func deepCopy(v any) any {
+	switch vm := v.(type) {
+	case map[string]any:
+		return deepCopyMap(vm)
 
-	for k, v := range data {
-		writeKey := fmt.Sprintf("%s%s", prefix, k)
-
-		switch vm := v.(type) {
-		case float64:
-			fmt.Fprintf(w, "%s %.f\n", writeKey, vm)
-
-		case map[string]any:
-			out(w, writeKey, vm)
-
-		default:
-			// Discard this value.
+	case []any:
+		result := make([]any, len(vm))
+		for i, e := range vm {
+			result[i] = deepCopy(e)
 		}
+		return result
+
+	default:
+		return vm
 	}
 }
@@ -2,6 +2,8 @@
 package publisher
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"sync"
 	"time"
@@ -18,28 +20,56 @@ type Collector interface {
 // =============================================================================
 
 // Publisher defines a handler function that will be called
-// on each interval.
-type Publisher func(map[string]any) error
+// on each interval. It must return when ctx is done.
+type Publisher func(ctx context.Context, data map[string]any) error
 
 // Publish provides the ability to receive metrics
 // on an interval.
 type Publish struct {
 	log       *slog.Logger
 	collector Collector
-	publisher []Publisher
+	workers   []*worker
 	wg        sync.WaitGroup
+	workersWg sync.WaitGroup
 	timer     *time.Timer
 	shutdown  chan struct{}
+	cancel    context.CancelFunc
 }
 
-// New creates a Publish for consuming and publishing metrics.
-func New(log *slog.Logger, collector Collector, interval time.Duration, publisher ...Publisher) (*Publish, error) {
+// New creates a Publish for consuming and publishing metrics. Each publisher
+// runs on its own worker, configured by opts.
+func New(log *slog.Logger, collector Collector, interval time.Duration, opts WorkerOptions, publishers ...Named) (*Publish, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	// the workers publish under ctx, cancelled when the shutdown is cut short
+	ctx, cancel := context.WithCancel(context.Background())
+
 	p := Publish{
 		log:       log,
 		collector: collector,
-		publisher: publisher,
 		timer:     time.NewTimer(interval),
 		shutdown:  make(chan struct{}),
+		cancel:    cancel,
+	}
+
+	names := make(map[string]bool, len(publishers))
+	for _, pub := range publishers {
+		if names[pub.Name] {
+			return nil, fmt.Errorf("publisher %q is registered twice", pub.Name)
+		}
+		names[pub.Name] = true
+
+		p.workers = append(p.workers, newWorker(log, opts, pub))
+	}
+
+	for _, w := range p.workers {
+		p.workersWg.Add(1)
+		go func(w *worker) {
+			defer p.workersWg.Done()
+			w.run(ctx)
+		}(w)
 	}
 
 	p.wg.Add(1)
@@ -61,13 +91,47 @@ func New(log *slog.Logger, collector Collector, interval time.Duration, publishe
 	return &p, nil
 }
 
-// Stop is used to shut down the goroutine collecting metrics.
-func (p *Publish) Stop() {
+// Stop is used to shut down the goroutine collecting metrics, and the
+// workers once they have published the queued data. If ctx is done first,
+// the publishes in flight are cancelled and the queued data is dropped.
+func (p *Publish) Stop(ctx context.Context) error {
 	close(p.shutdown)
 	p.wg.Wait()
+
+	for _, w := range p.workers {
+		close(w.queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.workersWg.Wait()
+		close(done)
+	}()
+
+	defer p.cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return fmt.Errorf("dropping the queued data: %w", ctx.Err())
+	}
 }
 
-// update pulls the metrics and publishes them to the specified system.
+// Stats returns the counters of the publishers, by name.
+func (p *Publish) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(p.workers))
+	for _, w := range p.workers {
+		stats[w.name] = w.stats()
+	}
+
+	return stats
+}
+
+// update pulls the metrics and queues them for each publisher. The data is
+// shared by the publishers, which must not modify it.
 func (p *Publish) update() error {
 	data, err := p.collector.Collect()
 	if err != nil {
@@ -75,11 +139,8 @@ func (p *Publish) update() error {
 		return err
 	}
 
-	for _, pub := range p.publisher {
-		err = pub(data)
-		if err != nil {
-			p.log.Error("publish", "status", "collect data", "publisher", pub, "msg", err)
-		}
+	for _, w := range p.workers {
+		w.push(data)
 	}
 
 	return nil
@@ -0,0 +1,138 @@
+package publisher
+
+import (
+	"context"
+	"expvar"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+type counterCollector struct {
+	n atomic.Int64
+}
+
+func (c *counterCollector) Collect() (map[string]any, error) {
+	return map[string]any{"n": float64(c.n.Add(1))}, nil
+}
+
+// statsOf reads the counters of a publisher from the expvars, they outlive
+// the Publish and are shared by the runs of a test.
+func statsOf(name string) Stats {
+	vars, ok := publisherVars.Get(name).(*expvar.Map)
+	if !ok {
+		return Stats{}
+	}
+
+	value := func(counter string) int64 {
+		if v, ok := vars.Get(counter).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	return Stats{Success: value("success"), Failure: value("failure"), Dropped: value("dropped")}
+}
+
+func TestPublishIsolation(t *testing.T) {
+	logger.Init(true)
+
+	var fast atomic.Int64
+	release := make(chan struct{})
+
+	publishers := []Named{
+		{Name: "test-slow", Publish: func(ctx context.Context, _ map[string]any) error {
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+		{Name: "test-fast", Publish: func(_ context.Context, _ map[string]any) error {
+			fast.Add(1)
+			return nil
+		}},
+	}
+
+	slow, fastBefore := statsOf("test-slow"), statsOf("test-fast")
+
+	p, err := New(logger.L, &counterCollector{}, 5*time.Millisecond, WorkerOptions{QueueSize: 1, Timeout: 50 * time.Millisecond}, publishers...)
+	assert.NoError(t, err)
+
+	// the fast publisher keeps publishing while the slow one times out
+	assert.Eventually(t, func() bool { return fast.Load() >= 5 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return p.Stats()["test-slow"].Failure > slow.Failure }, time.Second, time.Millisecond)
+
+	close(release)
+	assert.NoError(t, p.Stop(context.Background()))
+
+	stats := p.Stats()
+	assert.Equal(t, fast.Load(), stats["test-fast"].Success-fastBefore.Success)
+	assert.Equal(t, fastBefore.Failure, stats["test-fast"].Failure)
+	assert.Greater(t, stats["test-slow"].Dropped, slow.Dropped)
+}
+
+func TestPublishNames(t *testing.T) {
+	logger.Init(true)
+
+	pub := func(context.Context, map[string]any) error { return nil }
+
+	_, err := New(logger.L, &counterCollector{}, time.Second, DefaultWorkerOptions(), Named{Name: "test-twice", Publish: pub}, Named{Name: "test-twice", Publish: pub})
+	assert.EqualError(t, err, `publisher "test-twice" is registered twice`)
+
+	_, err = New(logger.L, &counterCollector{}, time.Second, WorkerOptions{Timeout: time.Second}, Named{Name: "test-queue", Publish: pub})
+	assert.EqualError(t, err, "the publisher queue size must be positive, got 0")
+}
+
+func TestWorkerDropsOldest(t *testing.T) {
+	logger.Init(true)
+
+	before := statsOf("test-drop")
+	w := newWorker(logger.L, WorkerOptions{QueueSize: 2, Timeout: time.Second}, Named{Name: "test-drop"})
+
+	for i := 1; i <= 5; i++ {
+		w.push(map[string]any{"n": float64(i)})
+	}
+
+	assert.Equal(t, before.Dropped+3, w.stats().Dropped)
+	assert.Equal(t, map[string]any{"n": float64(4)}, <-w.queue)
+	assert.Equal(t, map[string]any{"n": float64(5)}, <-w.queue)
+}
+
+func TestStopDropsQueued(t *testing.T) {
+	logger.Init(true)
+
+	started := make(chan struct{}, 1)
+	pub := func(ctx context.Context, _ map[string]any) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	before := statsOf("test-stop")
+
+	// the interval is long enough for the test to push the collections itself
+	p, err := New(logger.L, &counterCollector{}, time.Hour, WorkerOptions{QueueSize: 2, Timeout: time.Minute}, Named{Name: "test-stop", Publish: pub})
+	assert.NoError(t, err)
+
+	assert.NoError(t, p.update())
+	<-started
+	assert.NoError(t, p.update())
+	assert.NoError(t, p.update())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the publish in flight is cancelled, and the queued collections are dropped
+	assert.ErrorIs(t, p.Stop(ctx), context.DeadlineExceeded)
+
+	stats := p.Stats()["test-stop"]
+	assert.Equal(t, before.Failure+1, stats.Failure)
+	assert.Equal(t, before.Dropped+2, stats.Dropped)
+	assert.Equal(t, before.Success, stats.Success)
+}
@@ -0,0 +1,129 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// QueueOptions configures the batching and retries of push publishers.
+type QueueOptions struct {
+	// BatchSize is the maximum number of items sent in a request.
+	BatchSize int
+	// MaxPending bounds the items kept in memory while the receiver is
+	// failing, the oldest are dropped first.
+	MaxPending int
+}
+
+func DefaultQueueOptions() QueueOptions {
+	return QueueOptions{
+		BatchSize:  1000,
+		MaxPending: 100000,
+	}
+}
+
+func (o QueueOptions) Validate() error {
+	if o.BatchSize <= 0 {
+		return fmt.Errorf("the batch size must be positive, got %d", o.BatchSize)
+	}
+	if o.MaxPending < o.BatchSize {
+		return fmt.Errorf("the maximum of pending items must be at least the batch size, got %d", o.MaxPending)
+	}
+
+	return nil
+}
+
+// permanentError is an error that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as an error that retrying cannot fix, the items of a
+// batch failing with it are dropped.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// CheckResponse returns an error for responses that are not successful,
+// permanent unless the receiver is throttling or failing.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("unexpected status %d: %s", resp.StatusCode, msg)
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+
+	return Permanent(err)
+}
+
+// Queue batches the items of a push publisher, and keeps the batches that
+// failed with a temporary error to send them again with the next items.
+type Queue[T any] struct {
+	log  *slog.Logger
+	opts QueueOptions
+	send func(ctx context.Context, batch []T) error
+
+	mu      sync.Mutex
+	pending []T
+}
+
+// NewQueue creates a Queue sending batches with send.
+func NewQueue[T any](log *slog.Logger, opts QueueOptions, send func(ctx context.Context, batch []T) error) *Queue[T] {
+	return &Queue[T]{
+		log:  log,
+		opts: opts,
+		send: send,
+	}
+}
+
+// Push queues items and sends the pending items in batches, until a batch
+// fails. Batches failing with a permanent error are dropped.
+func (q *Queue[T]) Push(ctx context.Context, items []T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, items...)
+	if dropped := len(q.pending) - q.opts.MaxPending; dropped > 0 {
+		q.log.WarnContext(ctx, "queue", "status", "dropping the oldest pending items", "dropped", dropped)
+		q.pending = append(q.pending[:0], q.pending[dropped:]...)
+	}
+
+	for len(q.pending) > 0 {
+		n := min(len(q.pending), q.opts.BatchSize)
+
+		err := q.send(ctx, q.pending[:n])
+
+		var permanent *permanentError
+		if err != nil && !errors.As(err, &permanent) {
+			return fmt.Errorf("sending batch, %d items pending: %w", len(q.pending), err)
+		}
+
+		q.pending = append(q.pending[:0], q.pending[n:]...)
+
+		if err != nil {
+			return fmt.Errorf("dropping batch of %d items: %w", n, err)
+		}
+	}
+
+	return nil
+}
+
+// Pending returns the number of items waiting to be sent.
+func (q *Queue[T]) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
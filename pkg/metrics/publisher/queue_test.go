@@ -0,0 +1,42 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+func TestQueue(t *testing.T) {
+	logger.Init(true)
+
+	var sent [][]int
+	var fail error
+
+	q := NewQueue(logger.L, QueueOptions{BatchSize: 2, MaxPending: 4}, func(_ context.Context, batch []int) error {
+		if fail != nil {
+			return fail
+		}
+		sent = append(sent, append([]int(nil), batch...))
+		return nil
+	})
+
+	// temporary failures keep the items, up to the maximum
+	fail = errors.New("unavailable")
+	assert.Error(t, q.Push(context.Background(), []int{1, 2, 3}))
+	assert.Error(t, q.Push(context.Background(), []int{4, 5}))
+	assert.Equal(t, 4, q.Pending())
+
+	fail = nil
+	assert.NoError(t, q.Push(context.Background(), []int{6}))
+	assert.Equal(t, [][]int{{3, 4}, {5, 6}}, sent)
+	assert.Equal(t, 0, q.Pending())
+
+	// permanent failures drop the batch
+	fail = Permanent(errors.New("bad request"))
+	assert.EqualError(t, q.Push(context.Background(), []int{7}), "dropping batch of 1 items: bad request")
+	assert.Equal(t, 0, q.Pending())
+}
@@ -0,0 +1,171 @@
+// Package remotewrite manages the publishing of metrics to a Prometheus
+// remote-write receiver.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher/prometheus"
+)
+
+// version is the version of the remote-write protocol.
+const version = "0.1.0"
+
+type Options struct {
+	// URL is the remote-write endpoint, such as http://prometheus:9090/api/v1/write.
+	URL string
+	// Token is sent as a bearer token if set.
+	Token   logger.Secret
+	Timeout time.Duration
+	Queue   publisher.QueueOptions
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout: 10 * time.Second,
+		Queue:   publisher.DefaultQueueOptions(),
+	}
+}
+
+func (o Options) Validate() error {
+	if o.URL == "" {
+		return errors.New("the remote-write URL is required")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("the remote-write timeout must be positive, got %s", o.Timeout)
+	}
+
+	return o.Queue.Validate()
+}
+
+// Series is a sample of a time series, its labels include the metric name
+// as __name__ and are sorted by name.
+type Series struct {
+	Labels    []publisher.Label
+	Value     float64
+	Timestamp int64
+}
+
+// RemoteWrite sends the collected metrics as remote-write requests, snappy
+// compressed protobuf write requests.
+type RemoteWrite struct {
+	log    *slog.Logger
+	opts   Options
+	client http.Client
+	queue  *publisher.Queue[Series]
+}
+
+// New creates a RemoteWrite publisher.
+func New(opts Options, log *slog.Logger) *RemoteWrite {
+	rw := RemoteWrite{
+		log:    log,
+		opts:   opts,
+		client: http.Client{Timeout: opts.Timeout},
+	}
+	rw.queue = publisher.NewQueue(log, opts.Queue, rw.send)
+
+	return &rw
+}
+
+// Publish is called by the publisher goroutine and sends the metrics, with
+// those left from previous failures.
+func (rw *RemoteWrite) Publish(ctx context.Context, data map[string]any) error {
+	return rw.queue.Push(ctx, Convert(data, time.Now()))
+}
+
+func (rw *RemoteWrite) send(ctx context.Context, series []Series) error {
+	body := snappy.Encode(nil, Marshal(series))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return publisher.Permanent(err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", version)
+	if token := rw.opts.Token.Value(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return publisher.CheckResponse(resp)
+}
+
+// Convert returns the samples of data as series at time now, named like the
+// Prometheus publisher names them.
+func Convert(data map[string]any, now time.Time) []Series {
+	var series []Series
+	ts := now.UnixMilli()
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		labels := make([]publisher.Label, 0, len(s.Labels)+1)
+		labels = append(labels, publisher.Label{Name: "__name__", Value: prometheus.MetricName(s.Path)})
+
+		seen := map[string]bool{"__name__": true}
+		for _, l := range s.Labels {
+			name := prometheus.MetricName([]string{l.Name})
+			if seen[name] || l.Value == "" {
+				continue
+			}
+			seen[name] = true
+			labels = append(labels, publisher.Label{Name: name, Value: l.Value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		series = append(series, Series{Labels: labels, Value: s.Value, Timestamp: ts})
+	})
+
+	return series
+}
+
+// Marshal encodes series as a prometheus.WriteRequest message, with one
+// sample per time series.
+func Marshal(series []Series) []byte {
+	var b []byte
+
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+
+	return b
+}
@@ -0,0 +1,131 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+// unmarshal decodes a write request, as a remote-write receiver would.
+func unmarshal(t *testing.T, b []byte) []Series {
+	t.Helper()
+
+	var series []Series
+	each(t, b, func(_ protowire.Number, ts []byte) {
+		s := Series{}
+		each(t, ts, func(n protowire.Number, v []byte) {
+			switch n {
+			case 1:
+				l := publisher.Label{}
+				each(t, v, func(n protowire.Number, v []byte) {
+					if n == 1 {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+				})
+				s.Labels = append(s.Labels, l)
+			case 2:
+				for len(v) > 0 {
+					num, typ, n := protowire.ConsumeTag(v)
+					v = v[n:]
+					if num == 1 && typ == protowire.Fixed64Type {
+						bits, n := protowire.ConsumeFixed64(v)
+						s.Value = math.Float64frombits(bits)
+						v = v[n:]
+					} else {
+						ms, n := protowire.ConsumeVarint(v)
+						s.Timestamp = int64(ms)
+						v = v[n:]
+					}
+				}
+			}
+		})
+		series = append(series, s)
+	})
+
+	return series
+}
+
+// each calls fn with the length-delimited fields of the message b.
+func each(t *testing.T, b []byte, fn func(protowire.Number, []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.Equal(t, protowire.BytesType, typ)
+		b = b[n:]
+
+		v, n := protowire.ConsumeBytes(b)
+		assert.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		fn(num, v)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	logger.Init(true)
+
+	var received [][]Series
+	status := http.StatusTooManyRequests
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		compressed, _ := io.ReadAll(r.Body)
+		b, err := snappy.Decode(nil, compressed)
+		assert.NoError(t, err)
+
+		if status == http.StatusNoContent {
+			received = append(received, unmarshal(t, b))
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.URL = srv.URL + "/api/v1/write"
+	opts.Token = "secret"
+	opts.Queue.BatchSize = 2
+	assert.NoError(t, opts.Validate())
+
+	rw := New(opts, logger.L)
+
+	data := map[string]any{
+		"goroutines": 12.0,
+		"scrape": map[string]any{
+			"up": []publisher.Point{{Labels: []publisher.Label{{Name: "target", Value: "sco"}, {Name: "instance", Value: "10.0.0.1:8083"}}, Value: 1}},
+		},
+	}
+
+	// throttled requests are retried with the next publish
+	assert.Error(t, rw.Publish(context.Background(), data))
+	status = http.StatusNoContent
+	assert.NoError(t, rw.Publish(context.Background(), data))
+
+	assert.Len(t, received, 2)
+	all := append(received[0], received[1]...)
+	assert.Len(t, all, 4)
+
+	assert.Equal(t, []publisher.Label{{Name: "__name__", Value: "goroutines"}}, all[0].Labels)
+	assert.Equal(t, 12.0, all[0].Value)
+	assert.Equal(t, []publisher.Label{
+		{Name: "__name__", Value: "scrape_up"},
+		{Name: "instance", Value: "10.0.0.1:8083"},
+		{Name: "target", Value: "sco"},
+	}, all[1].Labels)
+	assert.InDelta(t, time.Now().UnixMilli(), all[1].Timestamp, 60000)
+}
@@ -0,0 +1,317 @@
+// Package statsd manages the publishing of metrics to a StatsD or DogStatsD
+// agent.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+const (
+	FlavorStatsD    = "statsd"
+	FlavorDogStatsD = "dogstatsd"
+
+	// unixPrefix marks addresses of Unix datagram sockets.
+	unixPrefix = "unix://"
+
+	// udpPacketSize keeps UDP packets within an Ethernet MTU, and
+	// unixPacketSize is the default buffer of the DogStatsD socket.
+	udpPacketSize  = 1432
+	unixPacketSize = 8192
+)
+
+type Options struct {
+	// Address is the host and port of the agent, or the path of its Unix
+	// datagram socket prefixed with unix://.
+	Address string
+	Flavor  string
+	// Prefix is prepended to the metric names.
+	Prefix string
+	// MaxPacketSize is the maximum size of a packet, metrics are batched up to
+	// it. It defaults to the MTU of UDP, or the buffer of Unix sockets.
+	MaxPacketSize int
+	// Timeout bounds the connection and the writes of a publish.
+	Timeout time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Address: "127.0.0.1:8125",
+		Flavor:  FlavorStatsD,
+		Timeout: time.Second,
+	}
+}
+
+func (o Options) Validate() error {
+	switch o.Flavor {
+	case FlavorStatsD, FlavorDogStatsD:
+	default:
+		return fmt.Errorf("unknown StatsD flavor %q, must be %s or %s", o.Flavor, FlavorStatsD, FlavorDogStatsD)
+	}
+	if o.Address == "" || o.Address == unixPrefix {
+		return errors.New("the StatsD address is required")
+	}
+	if o.MaxPacketSize < 0 {
+		return fmt.Errorf("the StatsD packet size must be positive, got %d", o.MaxPacketSize)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("the StatsD timeout must be positive, got %s", o.Timeout)
+	}
+
+	return nil
+}
+
+// StatsD emits the collected metrics as StatsD gauges and counters. Labels
+// become DogStatsD tags, or are appended to the name for plain StatsD, so
+// the segments of a path turned into labels by the rules become tags.
+// StatsD counters are increments, so counters are sent as the difference
+// with their previous value.
+type StatsD struct {
+	log        *slog.Logger
+	opts       Options
+	network    string
+	address    string
+	packetSize int
+
+	mu       sync.Mutex
+	conn     net.Conn
+	previous map[string]float64
+}
+
+// New creates a StatsD publisher, the agent is connected to on the first
+// publish, so it can start after the publisher.
+func New(opts Options, log *slog.Logger) *StatsD {
+	s := StatsD{
+		log:        log,
+		opts:       opts,
+		network:    "udp",
+		address:    opts.Address,
+		packetSize: udpPacketSize,
+		previous:   make(map[string]float64),
+	}
+
+	if path, ok := strings.CutPrefix(opts.Address, unixPrefix); ok {
+		s.network, s.address, s.packetSize = "unixgram", path, unixPacketSize
+	}
+	if opts.MaxPacketSize > 0 {
+		s.packetSize = opts.MaxPacketSize
+	}
+
+	return &s
+}
+
+// Publish is called by the publisher goroutine and sends the metrics.
+func (s *StatsD) Publish(ctx context.Context, data map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := time.Now().Add(s.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if s.conn == nil {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.DialContext(ctx, s.network, s.address)
+		if err != nil {
+			return fmt.Errorf("connecting to statsd: %w", err)
+		}
+		s.conn = conn
+	}
+
+	if err := s.conn.SetWriteDeadline(deadline); err != nil {
+		return s.reset(err)
+	}
+
+	lines, baselines := s.lines(data)
+	s.previous = baselines
+
+	// the increase of a counter is measured from the last value sent, so its
+	// baseline only moves once the packet of its line is written
+	var packet []byte
+	var pending []line
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet)
+		if err == nil {
+			for _, l := range pending {
+				if l.counter != "" {
+					s.previous[l.counter] = l.value
+				}
+			}
+		}
+		packet, pending = packet[:0], pending[:0]
+		return err
+	}
+
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+1+len(l.text) > s.packetSize {
+			if err := flush(); err != nil {
+				return s.reset(err)
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l.text...)
+		pending = append(pending, l)
+	}
+
+	if err := flush(); err != nil {
+		return s.reset(err)
+	}
+
+	return nil
+}
+
+// reset closes the connection after a write error, so the next publish
+// reconnects, for instance to a restarted agent.
+func (s *StatsD) reset(err error) error {
+	s.log.Warn("statsd", "status", "closing connection after write error", "address", s.opts.Address)
+
+	s.conn.Close()
+	s.conn = nil
+
+	return fmt.Errorf("writing to statsd: %w", err)
+}
+
+// Stop closes the connection to the agent.
+func (s *StatsD) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// line is a StatsD line, with the key and value of its counter, if any.
+type line struct {
+	text    string
+	counter string
+	value   float64
+}
+
+// lines renders data as StatsD lines, values that are not finite are
+// skipped. The first value of a counter only sets its baseline, and a
+// counter lower than its previous value is assumed to have been reset, and
+// a line larger than a packet is skipped. It also returns the baselines of
+// the counters, which keep their previous value while their line is unsent.
+func (s *StatsD) lines(data map[string]any) ([]line, map[string]float64) {
+	var lines []line
+	baselines := make(map[string]float64, len(s.previous))
+
+	// the reset of a signed gauge is skipped with its value, the value line
+	// being the longest
+	add := func(sample ...line) {
+		if text := sample[len(sample)-1].text; len(text) > s.packetSize {
+			s.log.Warn("statsd", "status", "skipping a line larger than a packet", "line", text, "size", s.packetSize)
+			return
+		}
+		lines = append(lines, sample...)
+	}
+
+	publisher.Walk(data, func(sample publisher.Sample) {
+		if math.IsInf(sample.Value, 0) || math.IsNaN(sample.Value) {
+			return
+		}
+
+		name, tags := s.name(sample)
+
+		switch sample.Kind {
+		case publisher.KindCounter:
+			key := name + tags
+			previous, seen := s.previous[key]
+			if !seen {
+				baselines[key] = sample.Value
+				return
+			}
+
+			delta := sample.Value - previous
+			if delta < 0 {
+				delta = sample.Value
+			}
+			if delta == 0 {
+				baselines[key] = sample.Value
+				return
+			}
+
+			baselines[key] = previous
+			add(line{text: name + ":" + format(delta) + "|c" + tags, counter: key, value: sample.Value})
+
+		default:
+			// a signed gauge is an increment, it must be set to zero first
+			gauge := line{text: name + ":" + format(sample.Value) + "|g" + tags}
+			if sample.Value < 0 {
+				add(line{text: name + ":0|g" + tags}, gauge)
+			} else {
+				add(gauge)
+			}
+		}
+	})
+
+	// the counters that are gone, like those of removed targets, are forgotten
+	return lines, baselines
+}
+
+// name returns the name of the sample, and its tags for DogStatsD.
+func (s *StatsD) name(sample publisher.Sample) (string, string) {
+	segments := make([]string, 0, len(sample.Path)+len(sample.Labels)+1)
+	if s.opts.Prefix != "" {
+		segments = append(segments, s.opts.Prefix)
+	}
+	for _, p := range sample.Path {
+		segments = append(segments, sanitize(p))
+	}
+
+	if s.opts.Flavor != FlavorDogStatsD {
+		for _, l := range sample.Labels {
+			segments = append(segments, sanitize(l.Value))
+		}
+		return strings.Join(segments, "."), ""
+	}
+
+	tags := make([]string, 0, len(sample.Labels))
+	for _, l := range sample.Labels {
+		tags = append(tags, sanitize(l.Name)+":"+sanitize(l.Value))
+	}
+
+	name := strings.Join(segments, ".")
+	if len(tags) == 0 {
+		return name, ""
+	}
+
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// sanitize replaces the characters that delimit the parts of a line.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
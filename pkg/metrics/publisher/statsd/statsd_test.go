@@ -0,0 +1,198 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+func data(gc float64) map[string]any {
+	return map[string]any{
+		"goroutines": 12.0,
+		"memstats":   map[string]any{"NumGC": publisher.Point{Kind: publisher.KindCounter, Value: gc}},
+		"health": []publisher.Point{
+			{Labels: []publisher.Label{{Name: "check", Value: "camel-k"}}, Value: -1},
+		},
+	}
+}
+
+// receive reads the packets sent to conn until none arrives for a while.
+func receive(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+
+	var packets []string
+	buf := make([]byte, 65536)
+	for {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestPublishUDP(t *testing.T) {
+	logger.Init(true)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	opts := DefaultOptions()
+	opts.Address = conn.LocalAddr().String()
+	opts.Prefix = "sco"
+	s := New(opts, logger.L)
+	defer s.Stop()
+
+	// the first value of a counter is its baseline
+	assert.NoError(t, s.Publish(context.Background(), data(3)))
+	assert.Equal(t, []string{"sco.goroutines:12|g\nsco.health.camel-k:0|g\nsco.health.camel-k:-1|g"}, receive(t, conn))
+
+	assert.NoError(t, s.Publish(context.Background(), data(5)))
+	assert.Equal(t, []string{"sco.goroutines:12|g\nsco.health.camel-k:0|g\nsco.health.camel-k:-1|g\nsco.memstats.NumGC:2|c"}, receive(t, conn))
+}
+
+func TestPublishDogStatsDUnix(t *testing.T) {
+	logger.Init(true)
+
+	path := filepath.Join(t.TempDir(), "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	opts := DefaultOptions()
+	opts.Address = "unix://" + path
+	opts.Flavor = FlavorDogStatsD
+	opts.MaxPacketSize = 45
+	s := New(opts, logger.L)
+	defer s.Stop()
+
+	assert.NoError(t, s.Publish(context.Background(), data(3)))
+
+	// packets are batched up to the maximum size
+	packets := receive(t, conn)
+	assert.Equal(t, []string{"goroutines:12|g\nhealth:0|g|#check:camel-k", "health:-1|g|#check:camel-k"}, packets)
+	for _, p := range packets {
+		assert.LessOrEqual(t, len(p), 45, strings.ReplaceAll(p, "\n", `\n`))
+	}
+}
+
+func TestPublishFailureKeepsBaselines(t *testing.T) {
+	logger.Init(true)
+
+	path := filepath.Join(t.TempDir(), "statsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.Address = "unix://" + path
+	s := New(opts, logger.L)
+	defer s.Stop()
+
+	assert.NoError(t, s.Publish(context.Background(), data(3)))
+	conn.Close()
+	assert.NoError(t, os.Remove(path))
+
+	// the increase of a failed publish is sent with the next one
+	assert.Error(t, s.Publish(context.Background(), data(4)))
+
+	conn, err = net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, s.Publish(context.Background(), data(5)))
+	assert.Contains(t, receive(t, conn)[0], "memstats.NumGC:2|c")
+}
+
+func TestPublishSkipsOversizedLines(t *testing.T) {
+	logger.Init(true)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	opts := DefaultOptions()
+	opts.Address = conn.LocalAddr().String()
+	opts.MaxPacketSize = 18
+	s := New(opts, logger.L)
+	defer s.Stop()
+
+	// the negative gauge needs 19 bytes, it is skipped with its reset
+	assert.NoError(t, s.Publish(context.Background(), data(3)))
+	assert.Equal(t, []string{"goroutines:12|g"}, receive(t, conn))
+}
+
+func TestPublishSkipsOversizedCounters(t *testing.T) {
+	logger.Init(true)
+
+	opts := DefaultOptions()
+	opts.MaxPacketSize = 17
+	s := New(opts, logger.L)
+	s.previous["memstats.NumGC"] = 3
+
+	// the increase of a skipped counter is not lost, it stays measured from
+	// the last value sent
+	lines, baselines := s.lines(data(5))
+	assert.Equal(t, []line{{text: "goroutines:12|g"}}, lines)
+	assert.Equal(t, map[string]float64{"memstats.NumGC": 3}, baselines)
+}
+
+// failingConn fails the writes after the first ones.
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if c.writes == 0 {
+		return 0, errors.New("connection refused")
+	}
+	c.writes--
+	return len(b), nil
+}
+
+func (c *failingConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *failingConn) Close() error                     { return nil }
+
+func TestPublishPartialFailure(t *testing.T) {
+	logger.Init(true)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	opts := DefaultOptions()
+	opts.Address = conn.LocalAddr().String()
+	opts.MaxPacketSize = 8
+	s := New(opts, logger.L)
+	defer s.Stop()
+
+	counters := func(a, b float64) map[string]any {
+		return map[string]any{
+			"a": publisher.Point{Kind: publisher.KindCounter, Value: a},
+			"b": publisher.Point{Kind: publisher.KindCounter, Value: b},
+		}
+	}
+
+	assert.NoError(t, s.Publish(context.Background(), counters(1, 1)))
+
+	// the packet of a is written before the one of b fails
+	s.conn.Close()
+	s.conn = &failingConn{writes: 1}
+	assert.Error(t, s.Publish(context.Background(), counters(3, 5)))
+
+	// only the increase of b is sent again
+	assert.NoError(t, s.Publish(context.Background(), counters(3, 5)))
+	assert.Equal(t, []string{"b:4|c"}, receive(t, conn))
+}
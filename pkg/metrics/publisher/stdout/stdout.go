@@ -17,30 +17,8 @@ func NewStdout(log *slog.Logger) *Stdout {
 }
 
 // Publish publishers for writing to stdout.
-func (s *Stdout) Publish(data map[string]any) error {
-	ctx := context.Background()
-
-	rawJSON, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	var d map[string]any
-	if err := json.Unmarshal(rawJSON, &d); err != nil {
-		return err
-	}
-
-	// Add heap value into the data set.
-	memStats, ok := (d["memstats"]).(map[string]any)
-	if ok {
-		d["heap"] = memStats["Alloc"]
-	}
-
-	// Remove unnecessary keys.
-	delete(d, "memstats")
-	delete(d, "cmdline")
-
-	out, err := json.MarshalIndent(d, "", "    ")
+func (s *Stdout) Publish(ctx context.Context, data map[string]any) error {
+	out, err := json.MarshalIndent(data, "", "    ")
 	if err != nil {
 		return err
 	}
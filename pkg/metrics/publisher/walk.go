@@ -0,0 +1,206 @@
+package publisher
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a metric, declared by a Point or inferred from its name.
+type Kind string
+
+const (
+	// KindGauge is a value that can go up and down.
+	KindGauge Kind = "gauge"
+	// KindCounter is a cumulative value that only goes up.
+	KindCounter Kind = "counter"
+	// KindHistogram is a sample of a histogram: one of its cumulative buckets,
+	// named with the _bucket suffix and labelled le with its upper bound, or
+	// its _sum or _count.
+	KindHistogram Kind = "histogram"
+	// KindSummary is a sample of a summary: one of its quantiles, labelled
+	// quantile, or its _sum or _count.
+	KindSummary Kind = "summary"
+)
+
+// Label is a name and value pair attached to a sample.
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Point is a labelled value, used as a leaf of the collected metrics when a
+// value needs labels, a kind other than the one inferred from its name or a
+// description. A leaf can also hold a slice of points sharing the same name.
+type Point struct {
+	Labels []Label `json:"labels,omitempty"`
+	Kind   Kind    `json:"kind,omitempty"`
+	Help   string  `json:"help,omitempty"`
+	Value  float64 `json:"value"`
+}
+
+// Sample is a numeric value found in the collected metrics.
+type Sample struct {
+	// Path holds the keys leading to the value, from the root of the data.
+	Path   []string
+	Labels []Label
+	Kind   Kind
+	// Help describes the metric, as given by the target it was collected
+	// from.
+	Help  string
+	Value float64
+}
+
+// Walk calls fn for every numeric value of data, in key order. Booleans are
+// reported as 1 and 0 and strings are skipped. The elements of an array are
+// reported with an index label, named index for the outermost array, then
+// index_2 and so on for nested arrays. Points are reported with their labels.
+func Walk(data map[string]any, fn func(Sample)) {
+	walkMap(nil, nil, data, fn)
+}
+
+func walkMap(path []string, labels []Label, data map[string]any, fn func(Sample)) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		walkValue(append(path[:len(path):len(path)], k), labels, data[k], fn)
+	}
+}
+
+func walkValue(path []string, labels []Label, v any, fn func(Sample)) {
+	switch t := v.(type) {
+	case map[string]any:
+		walkMap(path, labels, t, fn)
+
+	case Point:
+		walkPoint(path, labels, t, fn)
+
+	case []Point:
+		for _, p := range t {
+			walkPoint(path, labels, p, fn)
+		}
+
+	case []any:
+		name := "index"
+		if depth := indexDepth(labels); depth > 0 {
+			name = "index_" + strconv.Itoa(depth+1)
+		}
+
+		for i, e := range t {
+			walkValue(path, append(labels[:len(labels):len(labels)], Label{Name: name, Value: strconv.Itoa(i)}), e, fn)
+		}
+
+	default:
+		if value, ok := number(v); ok {
+			fn(Sample{Path: path, Labels: labels, Kind: InferKind(path), Value: value})
+		}
+	}
+}
+
+func walkPoint(path []string, labels []Label, p Point, fn func(Sample)) {
+	kind := p.Kind
+	if kind == "" {
+		kind = InferKind(path)
+	}
+
+	fn(Sample{Path: path, Labels: append(labels[:len(labels):len(labels)], p.Labels...), Kind: kind, Help: p.Help, Value: p.Value})
+}
+
+// Insert adds s to the tree of metrics, as a number if it has no labels, no
+// help and the kind inferred from its name, and as a Point otherwise. A sample whose
+// name is already a branch of the tree, or a leaf of a branch, is dropped.
+func Insert(tree map[string]any, s Sample) {
+	if len(s.Path) == 0 {
+		return
+	}
+
+	node := tree
+	for _, k := range s.Path[:len(s.Path)-1] {
+		switch next := node[k].(type) {
+		case nil:
+			m := make(map[string]any)
+			node[k] = m
+			node = m
+		case map[string]any:
+			node = next
+		default:
+			return
+		}
+	}
+
+	leaf := s.Path[len(s.Path)-1]
+	point := Point{Labels: s.Labels, Help: s.Help, Value: s.Value}
+	if s.Kind != InferKind(s.Path) {
+		point.Kind = s.Kind
+	}
+
+	switch existing := node[leaf].(type) {
+	case nil:
+		if len(point.Labels) == 0 && point.Kind == "" && point.Help == "" {
+			node[leaf] = s.Value
+			return
+		}
+		node[leaf] = []Point{point}
+	case []Point:
+		if len(point.Labels) > 0 {
+			node[leaf] = append(existing, point)
+		}
+	}
+}
+
+// IsIndexLabel reports whether a label is the index of an array element.
+func IsIndexLabel(l Label) bool {
+	return l.Name == "index" || strings.HasPrefix(l.Name, "index_")
+}
+
+func indexDepth(labels []Label) int {
+	depth := 0
+	for _, l := range labels {
+		if IsIndexLabel(l) {
+			depth++
+		}
+	}
+
+	return depth
+}
+
+func number(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// InferKind infers the kind of the metric at path from its name: names ending
+// with _total are counters, by convention, and any other name is a gauge.
+// Other counters must be declared, with a Point or a rule.
+func InferKind(path []string) Kind {
+	if len(path) > 0 && strings.HasSuffix(path[len(path)-1], "_total") {
+		return KindCounter
+	}
+
+	return KindGauge
+}
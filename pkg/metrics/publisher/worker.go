@@ -0,0 +1,157 @@
+package publisher
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The outcome of the publishes is published under the "publishers" expvar,
+// keyed by publisher name:
+//
+//	publishers.<name>.success  number of successful publishes
+//	publishers.<name>.failure  number of failed publishes
+//	publishers.<name>.dropped  number of collections dropped while queued
+var (
+	publisherVars = expvar.NewMap("publishers")
+	varsMutex     sync.Mutex
+)
+
+// WorkerOptions configures the workers running the publishers.
+type WorkerOptions struct {
+	// QueueSize bounds the collections waiting for a publisher still busy
+	// with a previous one, the oldest are dropped first.
+	QueueSize int
+	// Timeout bounds a publish, including the timeouts of the publisher
+	// itself, which only apply when they are shorter.
+	Timeout time.Duration
+}
+
+func DefaultWorkerOptions() WorkerOptions {
+	return WorkerOptions{
+		QueueSize: 2,
+		Timeout:   10 * time.Second,
+	}
+}
+
+func (o WorkerOptions) Validate() error {
+	if o.QueueSize <= 0 {
+		return fmt.Errorf("the publisher queue size must be positive, got %d", o.QueueSize)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("the publish timeout must be positive, got %s", o.Timeout)
+	}
+
+	return nil
+}
+
+// Named is a publisher with the name identifying it in logs and metrics.
+type Named struct {
+	Name    string
+	Publish Publisher
+}
+
+// Stats holds the counters of a publisher.
+type Stats struct {
+	Success int64
+	Failure int64
+	Dropped int64
+}
+
+// worker runs a publisher on its own goroutine, so a slow publisher neither
+// delays the others nor the collection.
+type worker struct {
+	log     *slog.Logger
+	name    string
+	publish Publisher
+	timeout time.Duration
+	queue   chan map[string]any
+
+	success *expvar.Int
+	failure *expvar.Int
+	dropped *expvar.Int
+}
+
+func newWorker(log *slog.Logger, opts WorkerOptions, p Named) *worker {
+	varsMutex.Lock()
+	defer varsMutex.Unlock()
+
+	vars, ok := publisherVars.Get(p.Name).(*expvar.Map)
+	if !ok {
+		vars = new(expvar.Map).Init()
+		publisherVars.Set(p.Name, vars)
+	}
+
+	counter := func(name string) *expvar.Int {
+		v, ok := vars.Get(name).(*expvar.Int)
+		if !ok {
+			v = new(expvar.Int)
+			vars.Set(name, v)
+		}
+		return v
+	}
+
+	return &worker{
+		log:     log,
+		name:    p.Name,
+		publish: p.Publish,
+		timeout: opts.Timeout,
+		queue:   make(chan map[string]any, opts.QueueSize),
+		success: counter("success"),
+		failure: counter("failure"),
+		dropped: counter("dropped"),
+	}
+}
+
+// push queues data, dropping the oldest queued data if the queue is full. It
+// must only be called from one goroutine.
+func (w *worker) push(data map[string]any) {
+	for {
+		select {
+		case w.queue <- data:
+			return
+		default:
+		}
+
+		select {
+		case <-w.queue:
+			w.dropped.Add(1)
+			w.log.Warn("publish", "status", "dropping the oldest queued data", "publisher", w.name)
+		default:
+		}
+	}
+}
+
+// run publishes the queued data until the queue is closed, the data queued
+// once ctx is done being dropped.
+func (w *worker) run(ctx context.Context) {
+	for data := range w.queue {
+		if ctx.Err() != nil {
+			w.dropped.Add(1)
+			w.log.Warn("publish", "status", "dropping the queued data on shutdown", "publisher", w.name)
+			continue
+		}
+
+		publishCtx, cancel := context.WithTimeout(ctx, w.timeout)
+		err := w.publish(publishCtx, data)
+		cancel()
+
+		if err != nil {
+			w.failure.Add(1)
+			w.log.Error("publish", "status", "publish data", "publisher", w.name, "msg", err)
+			continue
+		}
+		w.success.Add(1)
+	}
+}
+
+func (w *worker) stats() Stats {
+	return Stats{
+		Success: w.success.Value(),
+		Failure: w.failure.Value(),
+		Dropped: w.dropped.Value(),
+	}
+}
@@ -0,0 +1,204 @@
+// Package rates computes the rates and deltas of counters between
+// collections.
+package rates
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/collector"
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+	"github.com/sco1237896/sco-backend/pkg/metrics/rules"
+)
+
+const (
+	// RateSuffix and DeltaSuffix are appended to the name of a counter to
+	// name its rate and its delta.
+	RateSuffix  = "_rate"
+	DeltaSuffix = "_delta"
+)
+
+type Options struct {
+	// Rate selects, with globs of the rules syntax, the counters whose
+	// per-second rate since the previous collection is added.
+	Rate []string
+	// Delta selects the counters whose increase since the previous collection
+	// is added.
+	Delta []string
+}
+
+func DefaultOptions() Options {
+	return Options{}
+}
+
+func (o Options) Validate() error {
+	for _, globs := range [][]string{o.Rate, o.Delta} {
+		for _, g := range globs {
+			for _, segment := range strings.Split(g, ".") {
+				if _, err := path.Match(segment, ""); err != nil {
+					return fmt.Errorf("invalid counter glob %q: %w", g, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+// Collector adds to the metrics of a collector the rates and deltas of the
+// selected counters, as gauges named after the counter with RateSuffix and
+// DeltaSuffix. It keeps the previous value of every selected series,
+// identified by its name and labels, so the first value of a series is only a
+// baseline, and a series missing from a collection, like the series of a
+// target that could not be scraped, starts over. Only the samples of kind
+// publisher.KindCounter are counters, the other samples are never selected.
+//
+// A counter lower than its previous value has been reset, and so have the
+// other counters of its target: a restarted target resets all its counters,
+// including those that are already back above their previous value. The
+// increase of a reset counter is its value. Targets are identified by the
+// labels added by collector.Targets.
+type Collector struct {
+	collector publisher.Collector
+	opts      Options
+	now       func() time.Time
+
+	mu       sync.Mutex
+	previous map[string]sample
+}
+
+// NewCollector creates a Collector adding the rates and deltas of the
+// counters of collector selected by opts.
+func NewCollector(collector publisher.Collector, opts Options) *Collector {
+	return &Collector{
+		collector: collector,
+		opts:      opts,
+		now:       time.Now,
+		previous:  make(map[string]sample),
+	}
+}
+
+// Collect collects the metrics and adds the rates and deltas to them.
+func (c *Collector) Collect() (map[string]any, error) {
+	data, err := c.collector.Collect()
+	if err != nil {
+		return nil, err
+	}
+	if len(c.opts.Rate) == 0 && len(c.opts.Delta) == 0 {
+		return data, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type series struct {
+		sample      publisher.Sample
+		key         string
+		rate, delta bool
+	}
+
+	now := c.now()
+	current := make(map[string]sample, len(c.previous))
+	restarted := make(map[string]bool)
+	var selected []series
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		if s.Kind != publisher.KindCounter {
+			return
+		}
+
+		rate, delta := matchAny(c.opts.Rate, s.Path), matchAny(c.opts.Delta, s.Path)
+		if !rate && !delta || math.IsInf(s.Value, 0) || math.IsNaN(s.Value) {
+			return
+		}
+
+		key := seriesKey(s)
+		current[key] = sample{value: s.Value, at: now}
+		if previous, ok := c.previous[key]; ok && s.Value < previous.value {
+			restarted[targetKey(s.Labels)] = true
+		}
+
+		selected = append(selected, series{sample: s, key: key, rate: rate, delta: delta})
+	})
+
+	for _, s := range selected {
+		previous, ok := c.previous[s.key]
+		if !ok {
+			continue
+		}
+		elapsed := now.Sub(previous.at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		increase := s.sample.Value - previous.value
+		if restarted[targetKey(s.sample.Labels)] {
+			increase = s.sample.Value
+		}
+
+		if s.delta {
+			insert(data, s.sample, DeltaSuffix, increase)
+		}
+		if s.rate {
+			insert(data, s.sample, RateSuffix, increase/elapsed)
+		}
+	}
+
+	// forget the series that are gone, like those of removed targets
+	c.previous = current
+
+	return data, nil
+}
+
+func insert(data map[string]any, s publisher.Sample, suffix string, value float64) {
+	p := append([]string(nil), s.Path...)
+	p[len(p)-1] += suffix
+
+	publisher.Insert(data, publisher.Sample{Path: p, Labels: s.Labels, Kind: publisher.KindGauge, Value: value})
+}
+
+func matchAny(globs []string, p []string) bool {
+	for _, g := range globs {
+		if rules.Glob(g, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seriesKey identifies a series by its name and labels.
+func seriesKey(s publisher.Sample) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(s.Path, "."))
+	for _, l := range s.Labels {
+		b.WriteString("\x00" + l.Name + "=" + l.Value)
+	}
+
+	return b.String()
+}
+
+// targetKey identifies the target of a series by its target labels. Series
+// without them, collected from a single endpoint, share the same target.
+func targetKey(labels []publisher.Label) string {
+	var target, instance string
+	for _, l := range labels {
+		switch l.Name {
+		case collector.LabelTarget:
+			target = l.Value
+		case collector.LabelInstance:
+			instance = l.Value
+		}
+	}
+
+	return target + "\x00" + instance
+}
@@ -0,0 +1,102 @@
+package rates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+type fakeCollector struct {
+	data map[string]any
+}
+
+func (f *fakeCollector) Collect() (map[string]any, error) {
+	out := make(map[string]any, len(f.data))
+	for k, v := range f.data {
+		out[k] = v
+	}
+
+	return out, nil
+}
+
+func labels(target, instance string) []publisher.Label {
+	return []publisher.Label{{Name: "target", Value: target}, {Name: "instance", Value: instance}}
+}
+
+func TestCollect(t *testing.T) {
+	f := &fakeCollector{}
+	c := NewCollector(f, Options{Rate: []string{"memstats.NumGC"}, Delta: []string{"memstats.*Alloc"}})
+
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	counter := func(v float64) publisher.Point { return publisher.Point{Kind: publisher.KindCounter, Value: v} }
+
+	collect := func(gc, total float64) map[string]any {
+		f.data = map[string]any{"memstats": map[string]any{"NumGC": counter(gc), "TotalAlloc": counter(total), "HeapAlloc": 5.0}}
+		out, err := c.Collect()
+		assert.NoError(t, err)
+		now = now.Add(10 * time.Second)
+		return out["memstats"].(map[string]any)
+	}
+
+	// the first collection is only a baseline
+	assert.Equal(t, map[string]any{"NumGC": counter(10), "TotalAlloc": counter(1000), "HeapAlloc": 5.0}, collect(10, 1000))
+
+	out := collect(30, 1500)
+	assert.Equal(t, 2.0, out["NumGC_rate"])
+	assert.Equal(t, 500.0, out["TotalAlloc_delta"])
+	assert.NotContains(t, out, "NumGC_delta")
+
+	// a gauge is not a counter, even when a glob matches it
+	assert.NotContains(t, out, "HeapAlloc_delta")
+
+	// a reset counter resets the other counters of its target
+	out = collect(4, 2000)
+	assert.Equal(t, 0.4, out["NumGC_rate"])
+	assert.Equal(t, 2000.0, out["TotalAlloc_delta"])
+}
+
+func TestCollectTargets(t *testing.T) {
+	f := &fakeCollector{}
+	c := NewCollector(f, Options{Delta: []string{"requests_total"}})
+
+	collect := func(points ...publisher.Point) []publisher.Point {
+		f.data = map[string]any{"requests_total": points}
+		out, err := c.Collect()
+		assert.NoError(t, err)
+		deltas, _ := out["requests_total_delta"].([]publisher.Point)
+		return deltas
+	}
+
+	collect(
+		publisher.Point{Labels: labels("app", "10.0.0.1:8080"), Value: 100},
+		publisher.Point{Labels: labels("app", "10.0.0.2:8080"), Value: 200},
+	)
+
+	// a restarted target does not affect the others
+	assert.Equal(t, []publisher.Point{
+		{Labels: labels("app", "10.0.0.1:8080"), Value: 5},
+		{Labels: labels("app", "10.0.0.2:8080"), Value: 30},
+	}, collect(
+		publisher.Point{Labels: labels("app", "10.0.0.1:8080"), Value: 5},
+		publisher.Point{Labels: labels("app", "10.0.0.2:8080"), Value: 230},
+	))
+
+	// a target missing from a collection starts over
+	collect(publisher.Point{Labels: labels("app", "10.0.0.2:8080"), Value: 240})
+	assert.Equal(t, []publisher.Point{
+		{Labels: labels("app", "10.0.0.2:8080"), Value: 10},
+	}, collect(
+		publisher.Point{Labels: labels("app", "10.0.0.1:8080"), Value: 50},
+		publisher.Point{Labels: labels("app", "10.0.0.2:8080"), Value: 250},
+	))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Options{Rate: []string{"memstats.*", "**.requests_total"}}.Validate())
+	assert.EqualError(t, Options{Delta: []string{"memstats.["}}.Validate(), `invalid counter glob "memstats.[": syntax error in pattern`)
+}
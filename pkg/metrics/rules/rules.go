@@ -0,0 +1,437 @@
+// Package rules filters and reshapes the collected metrics before they are
+// published.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+// Action is what a rule does to the metrics it selects.
+type Action string
+
+const (
+	// ActionInclude drops the metrics that are not selected.
+	ActionInclude Action = "include"
+	// ActionExclude drops the selected metrics.
+	ActionExclude Action = "exclude"
+	// ActionRename replaces the name of the selected metrics with To. With a
+	// regex selector, To can reference its capture groups as $1 or ${name}.
+	ActionRename Action = "rename"
+	// ActionPrefix prepends To to the name of the selected metrics.
+	ActionPrefix Action = "prefix"
+	// ActionDropArrays drops the elements of arrays among the selected metrics.
+	ActionDropArrays Action = "drop_arrays"
+	// ActionLabels turns the segments of the name matched by the wildcards of
+	// the glob selector into labels, named by Labels in order. An empty label
+	// name keeps the segment in the name.
+	ActionLabels Action = "labels"
+	// ActionConvert converts the values of the selected metrics from the unit
+	// From to the unit To.
+	ActionConvert Action = "convert"
+	// ActionHistogram turns the selected histograms, published as a map of
+	// cumulative counts keyed by upper bound under buckets, with their sum and
+	// count, into histogram samples: the buckets become the samples of a single
+	// <name>.bucket metric labelled le.
+	ActionHistogram Action = "histogram"
+	// ActionKind declares the kind of the selected metrics, To is counter or
+	// gauge. Only names ending with _total are known to be counters otherwise.
+	ActionKind Action = "kind"
+)
+
+// units holds the factor from each supported unit to the base unit of its
+// dimension.
+var units = map[string]struct {
+	dimension string
+	factor    float64
+}{
+	"nanoseconds":  {"time", 1e-9},
+	"microseconds": {"time", 1e-6},
+	"milliseconds": {"time", 1e-3},
+	"seconds":      {"time", 1},
+	"minutes":      {"time", 60},
+	"bytes":        {"size", 1},
+	"kilobytes":    {"size", 1 << 10},
+	"megabytes":    {"size", 1 << 20},
+	"gigabytes":    {"size", 1 << 30},
+	"ratio":        {"fraction", 1},
+	"percent":      {"fraction", 0.01},
+}
+
+// Rule selects metrics by name and applies an action to them. Names are the
+// keys leading to a value joined with dots, like memstats.HeapAlloc.
+type Rule struct {
+	// Match selects metrics with a glob: * matches one segment of the name, or
+	// part of it, and ** any number of segments.
+	Match string `json:"match,omitempty"`
+	// Regex selects metrics with a regular expression matching the whole name.
+	Regex string `json:"regex,omitempty"`
+
+	Action Action   `json:"action"`
+	To     string   `json:"to,omitempty"`
+	From   string   `json:"from,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Rules is the content of a rules file.
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Default returns the rules used when none are configured: the command line
+// of the collected service and the arrays of the memory statistics, which
+// hold hundreds of entries, are dropped, the counters of the memory
+// statistics, the rate limiter and the health checks are declared, and the
+// kind and name of the health checks become labels, with their durations
+// published as a histogram. A rules file replaces them, so it must repeat
+// the rules it needs, as documented in the README.
+func Default() []Rule {
+	return []Rule{
+		{Match: "cmdline", Action: ActionExclude},
+		{Match: "memstats.BySize", Action: ActionExclude},
+		{Match: "memstats.**", Action: ActionDropArrays},
+		{Regex: `memstats\.(Mallocs|Frees|Lookups|TotalAlloc|PauseTotalNs|NumGC|NumForcedGC)`, Action: ActionKind, To: string(publisher.KindCounter)},
+		{Regex: `ratelimit\.(allowed|limited|rejected)_.*`, Action: ActionKind, To: string(publisher.KindCounter)},
+		{Match: "health.transitions.**", Action: ActionKind, To: string(publisher.KindCounter)},
+		{Match: "health.duration.*.*.**", Action: ActionLabels, Labels: []string{"kind", "check"}},
+		{Match: "health.duration", Action: ActionHistogram},
+		{Match: "health.*.*.*", Action: ActionLabels, Labels: []string{"", "kind", "check"}},
+	}
+}
+
+// Load reads the rules from a YAML or JSON file.
+func Load(file string) ([]Rule, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules: %w", err)
+	}
+
+	var r Rules
+	if err := yaml.UnmarshalStrict(b, &r); err != nil {
+		return nil, fmt.Errorf("parsing rules %s: %w", file, err)
+	}
+
+	return r.Rules, nil
+}
+
+type rule struct {
+	Rule
+	glob  []string
+	regex *regexp.Regexp
+	scale float64
+}
+
+// Engine applies rules to the collected metrics.
+type Engine struct {
+	rules []rule
+}
+
+// New compiles rules into an Engine, they are applied in order to every
+// metric.
+func New(rules []Rule) (*Engine, error) {
+	e := Engine{rules: make([]rule, 0, len(rules))}
+
+	for i, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		e.rules = append(e.rules, c)
+	}
+
+	return &e, nil
+}
+
+func compile(r Rule) (rule, error) {
+	c := rule{Rule: r}
+
+	switch {
+	case r.Match != "" && r.Regex != "":
+		return c, errors.New("match and regex are mutually exclusive")
+	case r.Match != "":
+		c.glob = strings.Split(r.Match, ".")
+		for _, g := range c.glob {
+			if _, err := path.Match(g, ""); err != nil {
+				return c, fmt.Errorf("invalid match %q: %w", r.Match, err)
+			}
+		}
+	case r.Regex != "":
+		re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return c, fmt.Errorf("invalid regex: %w", err)
+		}
+		c.regex = re
+	}
+
+	switch r.Action {
+	case ActionInclude, ActionExclude, ActionDropArrays, ActionHistogram:
+	case ActionRename, ActionPrefix:
+		if r.To == "" {
+			return c, fmt.Errorf("%s requires to", r.Action)
+		}
+	case ActionKind:
+		switch publisher.Kind(r.To) {
+		case publisher.KindCounter, publisher.KindGauge:
+		default:
+			return c, fmt.Errorf("unknown kind %q, must be %s or %s", r.To, publisher.KindCounter, publisher.KindGauge)
+		}
+	case ActionLabels:
+		if c.glob == nil {
+			return c, errors.New("labels requires a match glob")
+		}
+		if wildcards := len(wildcards(c.glob)); len(r.Labels) != wildcards {
+			return c, fmt.Errorf("labels has %d names for %d wildcards", len(r.Labels), wildcards)
+		}
+	case ActionConvert:
+		from, ok := units[r.From]
+		if !ok {
+			return c, fmt.Errorf("unknown unit %q", r.From)
+		}
+		to, ok := units[r.To]
+		if !ok {
+			return c, fmt.Errorf("unknown unit %q", r.To)
+		}
+		if from.dimension != to.dimension {
+			return c, fmt.Errorf("cannot convert %s to %s", r.From, r.To)
+		}
+		c.scale = from.factor / to.factor
+	default:
+		return c, fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	return c, nil
+}
+
+// Apply returns the metrics of data transformed by the rules. Values that
+// end up with labels, or with a kind that differs from the one inferred from
+// their new name, are returned as publisher.Point slices.
+func (e *Engine) Apply(data map[string]any) map[string]any {
+	out := make(map[string]any)
+
+	publisher.Walk(data, func(s publisher.Sample) {
+		s.Path = append([]string(nil), s.Path...)
+
+		for _, r := range e.rules {
+			var ok bool
+			if s, ok = r.apply(s); !ok {
+				return
+			}
+		}
+
+		publisher.Insert(out, s)
+	})
+
+	return out
+}
+
+// apply applies the rule to s, it returns false if s must be dropped.
+func (r *rule) apply(s publisher.Sample) (publisher.Sample, bool) {
+	if r.Action == ActionHistogram {
+		return r.histogram(s), true
+	}
+
+	name := strings.Join(s.Path, ".")
+	matched, groups := r.match(s.Path, name)
+
+	switch r.Action {
+	case ActionInclude:
+		return s, matched
+	case ActionExclude:
+		return s, !matched
+	}
+
+	if !matched {
+		return s, true
+	}
+
+	switch r.Action {
+	case ActionRename:
+		to := r.To
+		if r.regex != nil {
+			to = string(r.regex.ExpandString(nil, r.To, name, r.regex.FindStringSubmatchIndex(name)))
+		}
+		s.Path = strings.Split(to, ".")
+
+	case ActionPrefix:
+		s.Path = append(strings.Split(r.To, "."), s.Path...)
+
+	case ActionDropArrays:
+		for _, l := range s.Labels {
+			if publisher.IsIndexLabel(l) {
+				return s, false
+			}
+		}
+
+	case ActionLabels:
+		keep := make([]string, 0, len(s.Path))
+		labels := append([]publisher.Label(nil), s.Labels...)
+		for i, segment := range s.Path {
+			if name, ok := groups[i]; ok && name != "" {
+				labels = append(labels, publisher.Label{Name: name, Value: segment})
+				continue
+			}
+			keep = append(keep, segment)
+		}
+		s.Path, s.Labels = keep, labels
+
+	case ActionConvert:
+		s.Value *= r.scale
+
+	case ActionKind:
+		s.Kind = publisher.Kind(r.To)
+	}
+
+	return s, true
+}
+
+// histogram turns s into a histogram sample if it is a bucket, the sum or the
+// count of a histogram selected by the rule.
+func (r *rule) histogram(s publisher.Sample) publisher.Sample {
+	n := len(s.Path)
+
+	var root []string
+	var le string
+	switch {
+	case n >= 2 && s.Path[n-2] == "buckets":
+		root, le = s.Path[:n-2], s.Path[n-1]
+	case n >= 1 && (s.Path[n-1] == "sum" || s.Path[n-1] == "count"):
+		root = s.Path[:n-1]
+	default:
+		return s
+	}
+
+	if matched, _ := r.match(root, strings.Join(root, ".")); !matched {
+		return s
+	}
+
+	s.Kind = publisher.KindHistogram
+	if le == "" {
+		return s
+	}
+
+	s.Path = append(root[:len(root):len(root)], "bucket")
+	s.Labels = append(s.Labels[:len(s.Labels):len(s.Labels)], publisher.Label{Name: "le", Value: le})
+
+	return s
+}
+
+// match reports whether the rule selects the metric at p. For globs, it also
+// returns the label names of the segments matched by wildcards, by index.
+func (r *rule) match(p []string, name string) (bool, map[int]string) {
+	switch {
+	case r.regex != nil:
+		return r.regex.MatchString(name), nil
+	case r.glob != nil:
+		matched, ok := matchGlob(r.glob, p, 0)
+		if !ok {
+			return false, nil
+		}
+
+		groups := make(map[int]string)
+		for i, w := range matched {
+			if w >= 0 && w < len(r.Labels) {
+				groups[i] = r.Labels[w]
+			}
+		}
+
+		return true, groups
+	default:
+		return true, nil
+	}
+}
+
+// Glob reports whether the segments of a name match pattern, a glob with the
+// syntax of Rule.Match.
+func Glob(pattern string, p []string) bool {
+	_, ok := matchGlob(strings.Split(pattern, "."), p, 0)
+	return ok
+}
+
+// matchGlob matches the segments p against the glob. It returns, for each
+// segment, the index of the wildcard that matched it, or -1.
+func matchGlob(glob, p []string, wildcard int) ([]int, bool) {
+	if len(glob) == 0 {
+		return []int{}, len(p) == 0
+	}
+
+	if glob[0] == "**" {
+		for i := 0; i <= len(p); i++ {
+			if rest, ok := matchGlob(glob[1:], p[i:], wildcard); ok {
+				skipped := make([]int, i, i+len(rest))
+				for j := range skipped {
+					skipped[j] = -1
+				}
+
+				return append(skipped, rest...), true
+			}
+		}
+
+		return nil, false
+	}
+
+	if len(p) == 0 {
+		return nil, false
+	}
+	if ok, _ := path.Match(glob[0], p[0]); !ok {
+		return nil, false
+	}
+
+	w := -1
+	if strings.ContainsAny(glob[0], "*?[") {
+		w = wildcard
+		wildcard++
+	}
+
+	rest, ok := matchGlob(glob[1:], p[1:], wildcard)
+	if !ok {
+		return nil, false
+	}
+
+	return append([]int{w}, rest...), true
+}
+
+func wildcards(glob []string) []int {
+	var out []int
+	for i, g := range glob {
+		if g != "**" && strings.ContainsAny(g, "*?[") {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+// =============================================================================
+
+// Collector applies an Engine to the metrics of another collector.
+type Collector struct {
+	collector publisher.Collector
+	engine    *Engine
+}
+
+// NewCollector wraps collector, so its metrics are transformed by engine.
+func NewCollector(collector publisher.Collector, engine *Engine) *Collector {
+	return &Collector{
+		collector: collector,
+		engine:    engine,
+	}
+}
+
+// Collect collects the metrics and applies the rules to them.
+func (c *Collector) Collect() (map[string]any, error) {
+	data, err := c.collector.Collect()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.engine.Apply(data), nil
+}
@@ -0,0 +1,110 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sco1237896/sco-backend/pkg/metrics/publisher"
+)
+
+func TestApply(t *testing.T) {
+	rules, err := Load("testdata/rules.yaml")
+	assert.NoError(t, err)
+
+	engine, err := New(rules)
+	assert.NoError(t, err)
+
+	out := engine.Apply(map[string]any{
+		"cmdline":    []any{"sco", "serve"},
+		"goroutines": 12.0,
+		"memstats": map[string]any{
+			"HeapAlloc":    1024.0,
+			"PauseNs":      []any{1.0, 2.0},
+			"PauseTotalNs": 3e9,
+		},
+		"health": map[string]any{
+			"readiness": map[string]any{"camel-k": true},
+			"liveness":  map[string]any{"ping": false},
+		},
+	})
+
+	assert.Equal(t, map[string]any{
+		"sco": map[string]any{
+			"heap": map[string]any{"HeapAlloc": 1024.0},
+			"memstats": map[string]any{
+				"pause_seconds_total": 3.0,
+			},
+			"health": []publisher.Point{
+				{Labels: []publisher.Label{{Name: "kind", Value: "liveness"}, {Name: "check", Value: "ping"}}, Value: 0},
+				{Labels: []publisher.Label{{Name: "kind", Value: "readiness"}, {Name: "check", Value: "camel-k"}}, Value: 1},
+			},
+		},
+	}, out)
+}
+
+func TestDefault(t *testing.T) {
+	engine, err := New(Default())
+	assert.NoError(t, err)
+
+	out := engine.Apply(map[string]any{
+		"cmdline": []any{"sco"},
+		"memstats": map[string]any{
+			"Alloc":   1.0,
+			"NumGC":   3.0,
+			"PauseNs": []any{1.0, 2.0},
+			"BySize":  []any{map[string]any{"Size": 8.0}},
+		},
+		"ratelimit": map[string]any{"allowed_read": 5.0, "in_flight": 1.0},
+		"health": map[string]any{
+			"status":      map[string]any{"readiness": map[string]any{"camel-k": 1.0}},
+			"transitions": map[string]any{"readiness": map[string]any{"camel-k": 2.0}},
+			"duration": map[string]any{"readiness": map[string]any{"camel-k": map[string]any{
+				"buckets": map[string]any{"0.5": 1.0, "+Inf": 2.0},
+				"sum":     0.75,
+				"count":   2.0,
+			}}},
+		},
+	})
+
+	check := func(labels ...publisher.Label) []publisher.Label {
+		return append([]publisher.Label{{Name: "kind", Value: "readiness"}, {Name: "check", Value: "camel-k"}}, labels...)
+	}
+
+	assert.Equal(t, map[string]any{
+		"memstats": map[string]any{
+			"Alloc": 1.0,
+			"NumGC": []publisher.Point{{Kind: publisher.KindCounter, Value: 3}},
+		},
+		"ratelimit": map[string]any{
+			"allowed_read": []publisher.Point{{Kind: publisher.KindCounter, Value: 5}},
+			"in_flight":    1.0,
+		},
+		"health": map[string]any{
+			"status":      []publisher.Point{{Labels: check(), Value: 1}},
+			"transitions": []publisher.Point{{Labels: check(), Kind: publisher.KindCounter, Value: 2}},
+			"duration": map[string]any{
+				"bucket": []publisher.Point{
+					{Labels: check(publisher.Label{Name: "le", Value: "+Inf"}), Kind: publisher.KindHistogram, Value: 2},
+					{Labels: check(publisher.Label{Name: "le", Value: "0.5"}), Kind: publisher.KindHistogram, Value: 1},
+				},
+				"count": []publisher.Point{{Labels: check(), Kind: publisher.KindHistogram, Value: 2}},
+				"sum":   []publisher.Point{{Labels: check(), Kind: publisher.KindHistogram, Value: 0.75}},
+			},
+		},
+	}, out)
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	_, err := New([]Rule{{Match: "health.*", Action: ActionLabels}})
+	assert.EqualError(t, err, "rule 1: labels has 0 names for 1 wildcards")
+
+	_, err = New([]Rule{{Action: ActionConvert, From: "bytes", To: "seconds"}})
+	assert.EqualError(t, err, "rule 1: cannot convert bytes to seconds")
+
+	_, err = New([]Rule{{Action: ActionKind, To: "histogram"}})
+	assert.EqualError(t, err, `rule 1: unknown kind "histogram", must be counter or gauge`)
+
+	_, err = New([]Rule{{Action: "keep"}})
+	assert.EqualError(t, err, `rule 1: unknown action "keep"`)
+}
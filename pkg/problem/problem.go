@@ -0,0 +1,207 @@
+// Package problem implements RFC 7807 problem details for the HTTP API.
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+// ContentType is the media type of a problem document.
+const ContentType = "application/problem+json"
+
+// BannerKey is the gin context key of a banner, such as a maintenance notice,
+// that Abort adds to problem documents.
+const BannerKey = "problem.banner"
+
+// Code is a stable, machine readable identifier of a problem that clients
+// can switch on. Unlike the title and detail, codes never change.
+type Code string
+
+const (
+	CodeInternal        Code = "internal_error"
+	CodeBadRequest      Code = "bad_request"
+	CodeValidation      Code = "validation_failed"
+	CodeNotFound        Code = "not_found"
+	CodeAlreadyExists   Code = "already_exists"
+	CodeConflict        Code = "conflict"
+	CodeUnauthorized    Code = "unauthorized"
+	CodeForbidden       Code = "forbidden"
+	CodeInvalid         Code = "invalid"
+	CodeTimeout         Code = "timeout"
+	CodeTooManyRequests Code = "too_many_requests"
+	CodeUnavailable     Code = "service_unavailable"
+	CodeMaintenance     Code = "maintenance"
+)
+
+// FieldError describes a problem with a single field of a request or resource.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Problem is a problem details document as defined by RFC 7807, extended
+// with a stable error code and optional field errors.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Banner    string       `json:"banner,omitempty"`
+
+	cause error
+}
+
+var _ error = &Problem{}
+
+// New creates a problem with the given status, code and human readable detail.
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Wrap creates a problem that keeps err as its cause. The cause is only
+// used for logging and never rendered to clients.
+func Wrap(err error, status int, code Code, detail string) *Problem {
+	p := New(status, code, detail)
+	p.cause = err
+
+	return p
+}
+
+// Validation creates a problem reporting invalid request fields.
+func Validation(detail string, errs ...FieldError) *Problem {
+	p := New(http.StatusBadRequest, CodeValidation, detail)
+	p.Errors = errs
+
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", p.Code, p.Detail, p.cause)
+	}
+
+	return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// From converts err to a problem. Kubernetes API errors are mapped to the
+// matching HTTP status with a detail that does not expose the raw API server
+// message, validation and timeout errors get their own codes and anything
+// else becomes an internal error.
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		p := Validation("the request is not valid")
+		p.cause = err
+		for _, fe := range verrs {
+			p.Errors = append(p.Errors, FieldError{
+				Field:   fe.Namespace(),
+				Reason:  fe.Tag(),
+				Message: fe.Error(),
+			})
+		}
+
+		return p
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Wrap(err, http.StatusGatewayTimeout, CodeTimeout, "the request timed out")
+	}
+
+	var status k8serrors.APIStatus
+	if errors.As(err, &status) {
+		return fromStatus(err, status.Status())
+	}
+
+	return Wrap(err, http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+}
+
+func fromStatus(err error, status metav1.Status) *Problem {
+	switch {
+	case k8serrors.IsNotFound(err):
+		return Wrap(err, http.StatusNotFound, CodeNotFound, describe(status.Details, "not found"))
+	case k8serrors.IsAlreadyExists(err):
+		return Wrap(err, http.StatusConflict, CodeAlreadyExists, describe(status.Details, "already exists"))
+	case k8serrors.IsConflict(err):
+		return Wrap(err, http.StatusConflict, CodeConflict, describe(status.Details, "has been modified, please apply your changes to the latest version and try again"))
+	case k8serrors.IsForbidden(err):
+		return Wrap(err, http.StatusForbidden, CodeForbidden, "the backend is not allowed to perform this operation")
+	case k8serrors.IsInvalid(err):
+		p := Wrap(err, http.StatusUnprocessableEntity, CodeInvalid, describe(status.Details, "is invalid"))
+		if status.Details != nil {
+			for _, c := range status.Details.Causes {
+				p.Errors = append(p.Errors, FieldError{
+					Field:   c.Field,
+					Reason:  string(c.Type),
+					Message: c.Message,
+				})
+			}
+		}
+		return p
+	case k8serrors.IsTimeout(err), k8serrors.IsServerTimeout(err):
+		return Wrap(err, http.StatusGatewayTimeout, CodeTimeout, "the request to the cluster timed out")
+	case k8serrors.IsTooManyRequests(err), k8serrors.IsServiceUnavailable(err):
+		return Wrap(err, http.StatusServiceUnavailable, CodeUnavailable, "the cluster is temporarily unavailable")
+	default:
+		return Wrap(err, http.StatusInternalServerError, CodeInternal, "an unexpected error occurred")
+	}
+}
+
+func describe(details *metav1.StatusDetails, what string) string {
+	if details == nil || details.Name == "" {
+		return "resource " + what
+	}
+	if details.Kind == "" {
+		return fmt.Sprintf("%q %s", details.Name, what)
+	}
+
+	return fmt.Sprintf("%s %q %s", details.Kind, details.Name, what)
+}
+
+// Abort converts err to a problem, records err on the context so it is
+// logged by the request logger and writes the problem as the response.
+func Abort(c *gin.Context, err error) {
+	p := From(err)
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = logger.RequestID(c.Request.Context())
+	}
+	if p.Banner == "" {
+		p.Banner = c.GetString(BannerKey)
+	}
+
+	_ = c.Error(err)
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
@@ -0,0 +1,73 @@
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestFrom(t *testing.T) {
+	pipes := schema.GroupResource{Group: "camel.apache.org", Resource: "pipes"}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+		fields int
+	}{
+		{"not found", k8serrors.NewNotFound(pipes, "p1"), http.StatusNotFound, CodeNotFound, 0},
+		{"already exists", k8serrors.NewAlreadyExists(pipes, "p1"), http.StatusConflict, CodeAlreadyExists, 0},
+		{"conflict", k8serrors.NewConflict(pipes, "p1", errors.New("changed")), http.StatusConflict, CodeConflict, 0},
+		{"forbidden", k8serrors.NewForbidden(pipes, "p1", errors.New("denied")), http.StatusForbidden, CodeForbidden, 0},
+		{"invalid", k8serrors.NewInvalid(schema.GroupKind{Group: "camel.apache.org", Kind: "Pipe"}, "p1", field.ErrorList{
+			field.Required(field.NewPath("spec", "source"), "source is required"),
+			field.Required(field.NewPath("spec", "sink"), "sink is required"),
+		}), http.StatusUnprocessableEntity, CodeInvalid, 2},
+		{"server timeout", k8serrors.NewServerTimeout(pipes, "list", 1), http.StatusGatewayTimeout, CodeTimeout, 0},
+		{"deadline", fmt.Errorf("listing pipes: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout, 0},
+		{"wrapped status", fmt.Errorf("listing pipes: %w", k8serrors.NewNotFound(pipes, "p1")), http.StatusNotFound, CodeNotFound, 0},
+		{"problem", New(http.StatusBadRequest, CodeBadRequest, "bad"), http.StatusBadRequest, CodeBadRequest, 0},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, CodeInternal, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := From(tt.err)
+
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Len(t, p.Errors, tt.fields)
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/pipes/", nil)
+
+	Abort(c, k8serrors.NewForbidden(schema.GroupResource{Resource: "pipes"}, "", errors.New("system:serviceaccount:sco:default cannot list")))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "serviceaccount")
+
+	p := Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, CodeForbidden, p.Code)
+	assert.Equal(t, "/v1/pipes/", p.Instance)
+	assert.Len(t, c.Errors, 1)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sco1237896/sco-backend/pkg/health"
+	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/problem"
+)
+
+const (
+	// BannerHeader carries the maintenance banner on every response.
+	BannerHeader = "X-Banner"
+
+	maintenanceCheck = "maintenance"
+	maintenancePath  = "/admin/maintenance"
+)
+
+// Sources a maintenance mode change can come from.
+const (
+	MaintenanceSourceAPI    = "api"
+	MaintenanceSourceSignal = "signal"
+	MaintenanceSourceFile   = "file"
+)
+
+type MaintenanceOptions struct {
+	// AdminToken is the bearer token of the admin endpoints, which are not
+	// served if it is empty.
+	AdminToken logger.Secret
+
+	// Signal toggles maintenance mode on SIGUSR1.
+	Signal bool
+
+	// File enables maintenance mode while it exists, with its content as
+	// message. It is polled every FileInterval.
+	File         string
+	FileInterval time.Duration
+
+	// Degraded reports readiness as degraded during maintenance.
+	Degraded bool
+
+	// Message is the banner used when none is given.
+	Message string
+}
+
+func DefaultMaintenanceOptions() MaintenanceOptions {
+	return MaintenanceOptions{
+		FileInterval: 2 * time.Second,
+		Message:      "The API is in maintenance mode, pipes are read-only",
+	}
+}
+
+// MaintenanceStatus is the maintenance mode state served by the admin endpoint.
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Source  string     `json:"source,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+type maintenance struct {
+	opts MaintenanceOptions
+
+	mu     sync.RWMutex
+	status MaintenanceStatus
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newMaintenance(opts MaintenanceOptions) *maintenance {
+	return &maintenance{
+		opts: opts,
+		done: make(chan struct{}),
+	}
+}
+
+func (m *maintenance) get() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status
+}
+
+// set changes the maintenance mode and reports whether it actually changed.
+func (m *maintenance) set(enabled bool, message string, source string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		changed := m.status.Enabled
+		m.status = MaintenanceStatus{Source: source}
+		return changed
+	}
+
+	if message == "" {
+		message = m.opts.Message
+	}
+	if m.status.Enabled && m.status.Message == message {
+		return false
+	}
+
+	now := time.Now()
+	if m.status.Enabled {
+		now = *m.status.Since
+	}
+
+	m.status = MaintenanceStatus{
+		Enabled: true,
+		Message: message,
+		Source:  source,
+		Since:   &now,
+	}
+
+	return true
+}
+
+// banner adds the maintenance banner to the response headers and to problem documents.
+func (m *maintenance) banner(c *gin.Context) {
+	if st := m.get(); st.Enabled {
+		c.Header(BannerHeader, st.Message)
+		c.Set(problem.BannerKey, st.Message)
+	}
+
+	c.Next()
+}
+
+// readOnly rejects the requests that would change pipes during maintenance.
+func (m *maintenance) readOnly(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if st := m.get(); st.Enabled {
+			problem.Abort(c, problem.New(http.StatusServiceUnavailable, problem.CodeMaintenance, st.Message))
+			return
+		}
+	}
+
+	c.Next()
+}
+
+// check reports readiness as degraded during maintenance.
+func (m *maintenance) check(context.Context) error {
+	if st := m.get(); st.Enabled {
+		return health.Degraded(errors.New(st.Message))
+	}
+
+	return nil
+}
+
+// run toggles maintenance mode on signals and file changes until ctx is done
+// or stop is called.
+func (m *maintenance) run(ctx context.Context, l func(status MaintenanceStatus)) {
+	var signals chan os.Signal
+	if m.opts.Signal {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR1)
+		defer signal.Stop(signals)
+	}
+
+	var poll <-chan time.Time
+	if m.opts.File != "" && m.opts.FileInterval > 0 {
+		ticker := time.NewTicker(m.opts.FileInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	if signals == nil && poll == nil {
+		return
+	}
+
+	// the file only changes the mode when it is created, changed or removed,
+	// so it does not override changes made through the API or signals
+	var last *string
+	watch := func() {
+		content, err := os.ReadFile(m.opts.File)
+
+		var current *string
+		if err == nil {
+			message := strings.TrimSpace(string(content))
+			current = &message
+		}
+
+		switch {
+		case current == nil && last == nil:
+		case current != nil && last != nil && *current == *last:
+		case current == nil:
+			if m.set(false, "", MaintenanceSourceFile) {
+				l(m.get())
+			}
+		default:
+			if m.set(true, *current, MaintenanceSourceFile) {
+				l(m.get())
+			}
+		}
+
+		last = current
+	}
+
+	if poll != nil {
+		watch()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done:
+			return
+		case <-signals:
+			if m.set(!m.get().Enabled, "", MaintenanceSourceSignal) {
+				l(m.get())
+			}
+		case <-poll:
+			watch()
+		}
+	}
+}
+
+func (m *maintenance) stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// adminAuth requires the admin bearer token.
+func (m *maintenance) adminAuth(c *gin.Context) {
+	expected := []byte("Bearer " + m.opts.AdminToken.Value())
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+		problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "a valid admin token is required"))
+		return
+	}
+
+	c.Next()
+}
+
+func (s *Service) getMaintenance(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, s.maintenance.get())
+}
+
+func (s *Service) enableMaintenance(c *gin.Context) {
+	req := struct {
+		Message string `json:"message"`
+	}{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, problem.Wrap(err, http.StatusBadRequest, problem.CodeBadRequest, "the request body is not a valid maintenance request"))
+			return
+		}
+	}
+
+	if s.maintenance.set(true, req.Message, MaintenanceSourceAPI) {
+		s.maintenanceChanged(s.maintenance.get())
+	}
+
+	c.IndentedJSON(http.StatusOK, s.maintenance.get())
+}
+
+func (s *Service) disableMaintenance(c *gin.Context) {
+	if s.maintenance.set(false, "", MaintenanceSourceAPI) {
+		s.maintenanceChanged(s.maintenance.get())
+	}
+
+	c.IndentedJSON(http.StatusOK, s.maintenance.get())
+}
+
+func (s *Service) maintenanceChanged(status MaintenanceStatus) {
+	s.l.Info("maintenance mode changed", "enabled", status.Enabled, "message", status.Message, "source", status.Source)
+}
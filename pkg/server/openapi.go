@@ -0,0 +1,217 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	camelresources "github.com/apache/camel-k/v2/pkg/resources"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sco1237896/sco-backend/pkg/problem"
+)
+
+const (
+	openAPIPath = "/openapi.json"
+	docsPath    = "/docs"
+)
+
+// docsPage renders the OpenAPI document with Redoc, whose pinned bundle is
+// loaded from its CDN by the browser.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// OpenAPI returns the OpenAPI 3 document describing the routes served by the
+// backend. The document is built once and shared, callers must not modify it.
+var OpenAPI = sync.OnceValues(buildOpenAPI)
+
+func buildOpenAPI() (*openapi3.T, error) {
+	pipe, err := crdSchema("/crd/bases/camel.apache.org_pipes.yaml", "v1")
+	if err != nil {
+		return nil, err
+	}
+
+	problemSchema, err := openapi3gen.NewSchemaRefForValue(&problem.Problem{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Problem schema: %w", err)
+	}
+
+	maintenanceSchema, err := openapi3gen.NewSchemaRefForValue(&MaintenanceStatus{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate MaintenanceStatus schema: %w", err)
+	}
+
+	admin := openapi3.NewSecurityRequirements().With(openapi3.NewSecurityRequirement().Authenticate("admin"))
+
+	schemas := openapi3.Schemas{
+		"Problem":  problemSchema,
+		"Pipe":     openapi3.NewSchemaRef("", pipe),
+		"PipeList": openapi3.NewSchemaRef("", listSchema("PipeList", "Pipe")),
+
+		"MaintenanceStatus": maintenanceSchema,
+		"MaintenanceRequest": openapi3.NewSchemaRef("", openapi3.NewObjectSchema().
+			WithProperty("message", openapi3.NewStringSchema())),
+	}
+
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:   "SCO backend API",
+			Version: strings.TrimPrefix(version, "/"),
+		},
+		Components: &openapi3.Components{
+			Schemas: schemas,
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"admin": &openapi3.SecuritySchemeRef{
+					Value: openapi3.NewSecurityScheme().WithType("http").WithScheme("bearer").WithDescription("Admin token, the admin routes are only served when one is configured"),
+				},
+			},
+			Responses: openapi3.Responses{
+				"Problem": &openapi3.ResponseRef{
+					Value: openapi3.NewResponse().
+						WithDescription("Problem details as defined by RFC 7807").
+						WithContent(openapi3.Content{
+							problem.ContentType: openapi3.NewMediaType().WithSchemaRef(schemaRef("Problem")),
+						}),
+				},
+			},
+		},
+		Paths: openapi3.Paths{
+			version + "/pipes/": &openapi3.PathItem{
+				Get: operation("listPipes", "List pipes", "", http.StatusOK, "PipeList"),
+			},
+			version + maintenancePath: &openapi3.PathItem{
+				Get:    secured(operation("getMaintenance", "Get the maintenance mode", "", http.StatusOK, "MaintenanceStatus"), admin),
+				Put:    secured(operation("enableMaintenance", "Enable the maintenance mode, which makes pipes read-only", "MaintenanceRequest", http.StatusOK, "MaintenanceStatus"), admin),
+				Delete: secured(operation("disableMaintenance", "Disable the maintenance mode", "", http.StatusOK, "MaintenanceStatus"), admin),
+			},
+			version + openAPIPath: &openapi3.PathItem{
+				Get: &openapi3.Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "Get the OpenAPI document of this API",
+					Responses: openapi3.Responses{
+						"200": &openapi3.ResponseRef{
+							Value: openapi3.NewResponse().
+								WithDescription("OK").
+								WithContent(openapi3.NewContentWithJSONSchema(openapi3.NewObjectSchema())),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := openapi3.NewLoader().ResolveRefsIn(doc, nil); err != nil {
+		return nil, fmt.Errorf("failed to resolve OpenAPI references: %w", err)
+	}
+
+	return doc, nil
+}
+
+// operation describes an API operation. An empty request or response schema
+// means the operation has no body.
+func operation(id string, summary string, request string, status int, response string) *openapi3.Operation {
+	ok := openapi3.NewResponse().WithDescription(http.StatusText(status))
+	if response != "" {
+		ok = ok.WithContent(openapi3.NewContentWithJSONSchemaRef(schemaRef(response)))
+	}
+
+	op := &openapi3.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Responses: openapi3.Responses{
+			strconv.Itoa(status): &openapi3.ResponseRef{Value: ok},
+			"default": &openapi3.ResponseRef{
+				Ref: "#/components/responses/Problem",
+			},
+		},
+	}
+
+	if request != "" {
+		op.RequestBody = &openapi3.RequestBodyRef{
+			Value: openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(schemaRef(request)),
+		}
+	}
+
+	return op
+}
+
+func secured(op *openapi3.Operation, security *openapi3.SecurityRequirements) *openapi3.Operation {
+	op.Security = security
+
+	return op
+}
+
+func schemaRef(name string) *openapi3.SchemaRef {
+	return openapi3.NewSchemaRef("#/components/schemas/"+name, nil)
+}
+
+func listSchema(kind string, item string) *openapi3.Schema {
+	return openapi3.NewObjectSchema().
+		WithProperty("apiVersion", openapi3.NewStringSchema()).
+		WithProperty("kind", openapi3.NewStringSchema().WithEnum(kind)).
+		WithProperty("metadata", openapi3.NewObjectSchema().
+			WithProperty("resourceVersion", openapi3.NewStringSchema()).
+			WithProperty("continue", openapi3.NewStringSchema())).
+		WithProperty("items", &openapi3.Schema{
+			Type:  openapi3.TypeArray,
+			Items: schemaRef(item),
+		}).
+		WithProperty("banner", &openapi3.Schema{
+			Type:        openapi3.TypeString,
+			Description: "Maintenance banner, set during maintenance",
+		})
+}
+
+// crdSchema extracts the OpenAPI v3 schema of a served version from a CRD
+// bundled with Camel K, so the document always matches the API we build against.
+func crdSchema(name string, version string) (*openapi3.Schema, error) {
+	data, err := camelresources.Resource(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CRD %s: %w", name, err)
+	}
+
+	crd := struct {
+		Spec struct {
+			Versions []struct {
+				Name   string `json:"name"`
+				Schema struct {
+					OpenAPIV3Schema openapi3.Schema `json:"openAPIV3Schema"`
+				} `json:"schema"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}{}
+
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		return nil, fmt.Errorf("failed to decode CRD %s: %w", name, err)
+	}
+
+	for i := range crd.Spec.Versions {
+		if crd.Spec.Versions[i].Name == version {
+			return &crd.Spec.Versions[i].Schema.OpenAPIV3Schema, nil
+		}
+	}
+
+	return nil, fmt.Errorf("CRD %s does not define version %s", name, version)
+}
+
+func (s *Service) getOpenAPI(c *gin.Context) {
+	doc, err := OpenAPI()
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+func (s *Service) getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
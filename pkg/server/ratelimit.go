@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/sco1237896/sco-backend/pkg/problem"
+)
+
+const (
+	limiterIdleTimeout = 10 * time.Minute
+	limiterSweepPeriod = time.Minute
+)
+
+// rateLimitMetrics is published under /debug/vars so the metrics command picks it up.
+var rateLimitMetrics = expvar.NewMap("ratelimit")
+
+type RateLimitOptions struct {
+	// ReadRate and WriteRate are the sustained number of requests per second
+	// allowed for a single client, zero disables the limit.
+	ReadRate   float64
+	ReadBurst  int
+	WriteRate  float64
+	WriteBurst int
+
+	// MaxInFlight caps the number of requests served concurrently across all
+	// clients, zero disables the cap.
+	MaxInFlight int
+
+	// PrincipalHeader and APIKeyHeader, when set, are used in this order to
+	// identify a client. They are sent by the client, so they must only be set
+	// behind a trusted proxy that sets or strips them. Clients are identified
+	// by their IP otherwise.
+	PrincipalHeader string
+	APIKeyHeader    string
+}
+
+func DefaultRateLimitOptions() RateLimitOptions {
+	return RateLimitOptions{
+		ReadRate:    20,
+		ReadBurst:   40,
+		WriteRate:   5,
+		WriteBurst:  10,
+		MaxInFlight: 256,
+	}
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	opts     RateLimitOptions
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	limiters  map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+func newRateLimiter(opts RateLimitOptions) *rateLimiter {
+	rl := &rateLimiter{
+		opts:      opts,
+		limiters:  make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+	}
+
+	rateLimitMetrics.Set("in_flight", expvar.Func(func() any { return rl.inFlight.Load() }))
+	rateLimitMetrics.Set("clients", expvar.Func(func() any {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return len(rl.limiters)
+	}))
+
+	return rl
+}
+
+// middleware rejects requests over the global in-flight cap or over the
+// budget of the calling client with a 429 problem and a Retry-After header.
+func (rl *rateLimiter) middleware(c *gin.Context) {
+	if rl.opts.MaxInFlight > 0 {
+		if rl.inFlight.Add(1) > int64(rl.opts.MaxInFlight) {
+			rl.inFlight.Add(-1)
+			rateLimitMetrics.Add("rejected_in_flight", 1)
+			rl.reject(c, time.Second, "too many concurrent requests")
+			return
+		}
+		defer rl.inFlight.Add(-1)
+	}
+
+	class, limit, burst := "read", rl.opts.ReadRate, rl.opts.ReadBurst
+	if !isRead(c.Request.Method) {
+		class, limit, burst = "write", rl.opts.WriteRate, rl.opts.WriteBurst
+	}
+
+	if limit > 0 {
+		r := rl.limiter(class+":"+rl.clientKey(c), rate.Limit(limit), burst).Reserve()
+		if d := r.Delay(); !r.OK() || d > 0 {
+			r.Cancel()
+			rateLimitMetrics.Add("limited_"+class, 1)
+			rl.reject(c, d, "rate limit exceeded")
+			return
+		}
+	}
+
+	rateLimitMetrics.Add("allowed_"+class, 1)
+
+	c.Next()
+}
+
+func (rl *rateLimiter) reject(c *gin.Context, retryAfter time.Duration, detail string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 || retryAfter == rate.InfDuration {
+		seconds = 1
+	}
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeTooManyRequests, detail))
+}
+
+func (rl *rateLimiter) limiter(key string, limit rate.Limit, burst int) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(rl.lastSweep) > limiterSweepPeriod {
+		for k, e := range rl.limiters {
+			if now.Sub(e.lastSeen) > limiterIdleTimeout {
+				delete(rl.limiters, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	e, ok := rl.limiters[key]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		rl.limiters[key] = e
+	}
+	e.lastSeen = now
+
+	return e.limiter
+}
+
+// clientKey identifies the caller by principal or API key, if their headers
+// are configured, or by IP. API keys are
+// hashed so they are never kept in memory in clear text.
+func (rl *rateLimiter) clientKey(c *gin.Context) string {
+	if rl.opts.PrincipalHeader != "" {
+		if p := c.GetHeader(rl.opts.PrincipalHeader); p != "" {
+			return "principal:" + p
+		}
+	}
+	if rl.opts.APIKeyHeader != "" {
+		if k := c.GetHeader(rl.opts.APIKeyHeader); k != "" {
+			sum := sha256.Sum256([]byte(k))
+			return "apikey:" + hex.EncodeToString(sum[:8])
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func isRead(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
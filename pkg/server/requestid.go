@@ -0,0 +1,85 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sco1237896/sco-backend/pkg/logger"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+// requestID accepts the request ID sent by the client, or generates a new one,
+// stores it in the request context and returns it in the response headers.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.NewString()
+	}
+
+	c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+	c.Header(RequestIDHeader, id)
+
+	c.Next()
+}
+
+// validRequestID only accepts short IDs made of safe characters, so a client
+// cannot inject arbitrary content in logs and annotations.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Service) log(c *gin.Context) {
+	start := time.Now()
+
+	// handlers can rewrite the URL, log the one the client requested
+	urlPath := c.Request.URL.Path
+	urlQuery := c.Request.URL.RawQuery
+
+	c.Next()
+
+	fields := []any{
+		slog.Int("status", c.Writer.Status()),
+		slog.String("method", c.Request.Method),
+		slog.String("path", urlPath),
+		slog.String("route", c.FullPath()),
+		slog.String("query", urlQuery),
+		slog.String("ip", c.ClientIP()),
+		slog.String("user-agent", c.Request.UserAgent()),
+		slog.Duration("latency", time.Since(start)),
+	}
+
+	msg := "Incoming request"
+	if len(c.Errors) > 0 {
+		msg = c.Errors.String()
+	}
+
+	switch {
+	case c.Writer.Status() >= 500:
+		s.l.ErrorContext(c.Request.Context(), msg, fields...)
+	case c.Writer.Status() >= 400:
+		s.l.WarnContext(c.Request.Context(), msg, fields...)
+	default:
+		s.l.InfoContext(c.Request.Context(), msg, fields...)
+	}
+}
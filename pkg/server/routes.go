@@ -8,8 +8,22 @@ func (s *Service) routes(engine *gin.Engine) {
 	v1 := engine.Group(version)
 
 	// Add routes for pipes
-	pipes := v1.Group("/pipes")
+	pipes := v1.Group("/pipes", s.maintenance.readOnly)
 	pipes.GET("/", s.getPipes)
 
+	// Add admin routes, only if they can be protected
+	if s.opts.Maintenance.AdminToken != "" {
+		admin := v1.Group("", s.maintenance.adminAuth)
+		admin.GET(maintenancePath, s.getMaintenance)
+		admin.PUT(maintenancePath, s.enableMaintenance)
+		admin.DELETE(maintenancePath, s.disableMaintenance)
+	}
+
+	// Add API documentation
+	v1.GET(openAPIPath, s.getOpenAPI)
+	if s.opts.Development {
+		v1.GET(docsPath, s.getDocs)
+	}
+
 	// Add rest of routes
 }
@@ -5,33 +5,53 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync/atomic"
 	"time"
 
+	camelv1 "github.com/apache/camel-k/v2/pkg/apis/camel/v1"
 	"github.com/gin-gonic/gin"
-	sloggin "github.com/samber/slog-gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
 	"github.com/sco1237896/sco-backend/pkg/client"
 	"github.com/sco1237896/sco-backend/pkg/health"
 	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/problem"
+	"github.com/sco1237896/sco-backend/pkg/tracing"
 )
 
+// CamelKCheck is the name of the health check of Camel K.
+const CamelKCheck = "camel-k"
+
 type Options struct {
+	Development       bool
 	Addr              string
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
 	ReadHeaderTimeout time.Duration
 	ShutdownTimeout   time.Duration
+	// PreStopDelay is how long the server keeps serving after readiness
+	// started failing, so load balancers can stop routing traffic to it.
+	PreStopDelay time.Duration
+	RateLimit    RateLimitOptions
+	Checks       client.ChecksOptions
+	Maintenance  MaintenanceOptions
 }
 
 type Service struct {
 	opts    *Options
 	l       *slog.Logger
 	cl      client.Interface
+	checks  []client.NamedCheck
 	health  *health.Service
 	svr     *http.Server
 	running atomic.Bool
+	// listening is set once the server accepts connections
+	listening atomic.Bool
+
+	maintenance *maintenance
 }
 
 func DefaultOptions() Options {
@@ -42,15 +62,45 @@ func DefaultOptions() Options {
 		IdleTimeout:       30 * time.Second,
 		ReadHeaderTimeout: 2 * time.Second,
 		ShutdownTimeout:   10 * time.Second,
+		PreStopDelay:      5 * time.Second,
+		RateLimit:         DefaultRateLimitOptions(),
+		Checks:            client.DefaultChecksOptions(),
+		Maintenance:       DefaultMaintenanceOptions(),
+	}
+}
+
+func (o Options) Validate() error {
+	if o.Addr == "" {
+		return errors.New("the server address is required")
+	}
+	for _, d := range []time.Duration{o.ReadTimeout, o.WriteTimeout, o.IdleTimeout, o.ReadHeaderTimeout, o.ShutdownTimeout, o.PreStopDelay} {
+		if d < 0 {
+			return errors.New("server timeouts must not be negative")
+		}
+	}
+	if o.RateLimit.ReadRate < 0 || o.RateLimit.WriteRate < 0 || o.RateLimit.MaxInFlight < 0 {
+		return errors.New("rate limits must not be negative")
+	}
+	if (o.RateLimit.ReadRate > 0 && o.RateLimit.ReadBurst < 1) || (o.RateLimit.WriteRate > 0 && o.RateLimit.WriteBurst < 1) {
+		return errors.New("rate limit bursts must be at least 1")
+	}
+	if o.Maintenance.File != "" && o.Maintenance.FileInterval <= 0 {
+		return errors.New("the maintenance file interval must be positive")
+	}
+
+	for _, co := range []client.CheckOptions{o.Checks.CRDs, o.Checks.APILatency, o.Checks.RBAC, o.Checks.Operator} {
+		if co.Severity != client.SeverityDisabled && (co.Name == CamelKCheck || co.Name == maintenanceCheck) {
+			return fmt.Errorf("health check %s is registered by the server", co.Name)
+		}
 	}
+
+	return o.Checks.Validate()
 }
 
 func New(opts Options, cl client.Interface, health *health.Service, l *slog.Logger) *Service {
 	l = l.WithGroup("server")
 
 	r := gin.New()
-	r.Use(gin.Recovery())
-	r.Use(sloggin.New(l))
 
 	svr := &http.Server{
 		ReadTimeout:       opts.ReadTimeout,
@@ -65,11 +115,21 @@ func New(opts Options, cl client.Interface, health *health.Service, l *slog.Logg
 	s := &Service{
 		l:      logger.With(slog.String("component", "server")),
 		cl:     cl,
+		checks: cl.Checks(opts.Checks),
 		health: health,
 		opts:   &opts,
 		svr:    svr,
+
+		maintenance: newMaintenance(opts.Maintenance),
 	}
 
+	r.Use(requestID)
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.Use(gin.Recovery())
+	r.Use(s.log)
+	r.Use(newRateLimiter(opts.RateLimit).middleware)
+	r.Use(s.maintenance.banner)
+
 	s.routes(r)
 
 	return s
@@ -79,20 +139,33 @@ func (s *Service) Start(c context.Context) error {
 	s.l.InfoContext(c, "Starting server")
 
 	if s.health != nil {
-		s.health.AddReadinessCheck(s.serverName()+"1", func() error {
+		s.health.AddReadinessCheck(s.serverName(), func(context.Context) error {
 			if s.running.Load() {
 				return nil
-
 			}
 			return errors.New(s.serverName() + " is not running")
 		})
-		s.health.AddReadinessCheck(s.serverName()+"2", func() error {
-			return s.cl.Check(c)
-		})
+		s.health.AddReadinessCheck(CamelKCheck, s.cl.Check)
+
+		for _, nc := range s.checks {
+			s.health.AddReadinessCheck(nc.Name, severity(nc))
+		}
+		if s.opts.Maintenance.Degraded {
+			s.health.AddReadinessCheck(maintenanceCheck, s.maintenance.check)
+		}
 	}
 
+	go s.maintenance.run(c, s.maintenanceChanged)
+
 	if s.running.CompareAndSwap(false, true) {
-		err := s.svr.ListenAndServe()
+		ln, err := net.Listen("tcp", s.svr.Addr)
+		if err != nil {
+			s.running.CompareAndSwap(true, false)
+			return err
+		}
+		s.listening.Store(true)
+
+		err = s.svr.Serve(ln)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.running.CompareAndSwap(true, false)
 			return err
@@ -102,12 +175,42 @@ func (s *Service) Start(c context.Context) error {
 	return nil
 }
 
+// Ready reports whether the server accepts connections.
+func (s *Service) Ready() bool {
+	return s.running.Load() && s.listening.Load()
+}
+
+// Stop drains the server: readiness is failed first, then the server keeps
+// serving for PreStopDelay before it stops accepting connections and waits up
+// to ShutdownTimeout for in-flight requests to complete.
 func (s *Service) Stop(ctx context.Context) error {
 	if s.health != nil {
+		s.health.Drain()
 		s.health.RemoveReadinessCheck(s.serverName())
+		s.health.RemoveReadinessCheck(CamelKCheck)
+
+		for _, nc := range s.checks {
+			s.health.RemoveReadinessCheck(nc.Name)
+		}
+		s.health.RemoveReadinessCheck(maintenanceCheck)
 	}
 
+	s.maintenance.stop()
+
 	if s.running.CompareAndSwap(true, false) {
+		s.listening.Store(false)
+
+		if s.opts.PreStopDelay > 0 {
+			s.l.InfoContext(ctx, "Waiting before stopping the server", slog.Duration("delay", s.opts.PreStopDelay))
+
+			select {
+			case <-time.After(s.opts.PreStopDelay):
+			case <-ctx.Done():
+			}
+		}
+
+		s.l.InfoContext(ctx, "Draining the server", slog.Duration("timeout", s.opts.ShutdownTimeout))
+
 		tctx, cancel := context.WithTimeout(ctx, s.opts.ShutdownTimeout)
 		defer cancel()
 
@@ -120,14 +223,32 @@ func (s *Service) Stop(ctx context.Context) error {
 	return nil
 }
 
+// severity applies the severity of nc to its failures: warnings are reported
+// as degraded instead of failing readiness.
+func severity(nc client.NamedCheck) health.Check {
+	if nc.Severity != client.SeverityWarning {
+		return nc.Check
+	}
+
+	return func(ctx context.Context) error {
+		return health.Degraded(nc.Check(ctx))
+	}
+}
+
 func (s *Service) getPipes(c *gin.Context) {
-	list, err := s.cl.ListPipes(context.Background())
+	list, err := s.cl.ListPipes(c.Request.Context())
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		problem.Abort(c, err)
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, list)
+	c.IndentedJSON(http.StatusOK, pipeList{PipeList: list, Banner: c.GetString(problem.BannerKey)})
+}
+
+// pipeList is a list of pipes together with the maintenance banner, if any.
+type pipeList struct {
+	*camelv1.PipeList
+	Banner string `json:"banner,omitempty"`
 }
 
 func (s *Service) serverName() string {
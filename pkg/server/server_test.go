@@ -4,9 +4,17 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
 
 	"github.com/sco1237896/sco-backend/pkg/logger"
+	"github.com/sco1237896/sco-backend/pkg/problem"
 
 	"github.com/sco1237896/sco-backend/test/client"
 
@@ -15,6 +23,8 @@ import (
 	"k8s.io/apimachinery/pkg/util/json"
 )
 
+var ginPathParams = regexp.MustCompile(`:([^/]+)`)
+
 func TestGetPipes(t *testing.T) {
 	logger.Init(true)
 
@@ -40,3 +50,176 @@ func TestGetPipes(t *testing.T) {
 	assert.Equal(t, "mykb1", list.Items[0].Name)
 	assert.Equal(t, "mykb2", list.Items[1].Name)
 }
+
+func TestOpenAPI(t *testing.T) {
+	logger.Init(true)
+
+	doc, err := OpenAPI()
+	assert.NoError(t, err)
+	assert.NoError(t, doc.Validate(context.Background()))
+
+	// the admin routes are only served with a token
+	serverOpts := DefaultOptions()
+	serverOpts.Maintenance.AdminToken = "admin"
+	server := New(serverOpts, &client.TestClient{}, nil, logger.L)
+
+	engine, ok := server.svr.Handler.(*gin.Engine)
+	assert.True(t, ok)
+
+	for _, r := range engine.Routes() {
+		path := doc.Paths.Find(ginPathParams.ReplaceAllString(r.Path, "{$1}"))
+		if assert.NotNil(t, path, "route %s %s is not documented", r.Method, r.Path) {
+			assert.NotNil(t, path.GetOperation(r.Method), "route %s %s is not documented", r.Method, r.Path)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	logger.Init(true)
+
+	serverOpts := DefaultOptions()
+	serverOpts.RateLimit.ReadRate = 1
+	serverOpts.RateLimit.ReadBurst = 2
+
+	get := func(server *Service, apiKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/v1/pipes/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		server.svr.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// by default clients are identified by IP, whatever headers they send
+	server := New(serverOpts, &client.TestClient{}, nil, logger.L)
+	assert.Equal(t, http.StatusOK, get(server, "a").Code)
+	assert.Equal(t, http.StatusOK, get(server, "b").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get(server, "c").Code)
+
+	serverOpts.RateLimit.APIKeyHeader = "X-API-Key"
+	server = New(serverOpts, &client.TestClient{}, nil, logger.L)
+
+	assert.Equal(t, http.StatusOK, get(server, "a").Code)
+	assert.Equal(t, http.StatusOK, get(server, "a").Code)
+
+	w := get(server, "a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+	// other clients have their own budget
+	assert.Equal(t, http.StatusOK, get(server, "b").Code)
+}
+
+func TestRequestID(t *testing.T) {
+	logger.Init(true)
+
+	serverOpts := DefaultOptions()
+	serverOpts.RateLimit.ReadRate = 1
+	serverOpts.RateLimit.ReadBurst = 1
+	server := New(serverOpts, &client.TestClient{}, nil, logger.L)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/v1/pipes/", nil)
+	req.Header.Set(RequestIDHeader, "support-1234")
+	server.svr.Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "support-1234", w.Header().Get(RequestIDHeader))
+
+	// unsafe IDs are replaced and the ID is part of error bodies
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, "/v1/pipes/", nil)
+	req.Header.Set(RequestIDHeader, "bad\nid")
+	server.svr.Handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(RequestIDHeader)
+	assert.NotEmpty(t, id)
+	assert.NotEqual(t, "bad\nid", id)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	p := problem.Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, problem.CodeTooManyRequests, p.Code)
+	assert.Equal(t, id, p.RequestID)
+}
+
+func TestMaintenance(t *testing.T) {
+	logger.Init(true)
+
+	serverOpts := DefaultOptions()
+	serverOpts.Maintenance.AdminToken = "admin"
+	server := New(serverOpts, &client.TestClient{}, nil, logger.L)
+
+	// the API has no writes yet, test the read-only pipes with one
+	engine, ok := server.svr.Handler.(*gin.Engine)
+	assert.True(t, ok)
+	engine.DELETE("/v1/pipes/:namespace/:name", server.maintenance.readOnly, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	do := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(context.Background(), method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		server.svr.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPut, "/v1/admin/maintenance", "", "wrong").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/v1/admin/maintenance", `{"message":"upgrading Camel K"}`, "admin").Code)
+
+	// reads keep working and carry the banner
+	w := do(http.MethodGet, "/v1/pipes/", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "upgrading Camel K", w.Header().Get(BannerHeader))
+
+	list := struct {
+		Kind   string `json:"kind"`
+		Banner string `json:"banner"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, "upgrading Camel K", list.Banner)
+
+	w = do(http.MethodDelete, "/v1/pipes/ns/p1", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	p := problem.Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, problem.CodeMaintenance, p.Code)
+	assert.Equal(t, "upgrading Camel K", p.Banner)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/admin/maintenance", "", "admin").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/v1/pipes/ns/p1", "", "").Code)
+	w = do(http.MethodGet, "/v1/pipes/", "", "")
+	assert.Empty(t, w.Header().Get(BannerHeader))
+	assert.NotContains(t, w.Body.String(), "banner")
+}
+
+func TestMaintenanceFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance")
+
+	opts := DefaultMaintenanceOptions()
+	opts.File = file
+	opts.FileInterval = 5 * time.Millisecond
+	m := newMaintenance(opts)
+
+	go m.run(context.Background(), func(MaintenanceStatus) {})
+	t.Cleanup(m.stop)
+
+	assert.NoError(t, os.WriteFile(file, []byte("operator upgrade\n"), 0o600))
+	assert.Eventually(t, func() bool { return m.get().Enabled }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "operator upgrade", m.get().Message)
+	assert.Equal(t, MaintenanceSourceFile, m.get().Source)
+
+	assert.NoError(t, os.Remove(file))
+	assert.Eventually(t, func() bool { return !m.get().Enabled }, time.Second, 5*time.Millisecond)
+}
+
+func TestValidateCheckNames(t *testing.T) {
+	opts := DefaultOptions()
+	assert.NoError(t, opts.Validate())
+
+	opts.Checks.Operator.Name = CamelKCheck
+	assert.EqualError(t, opts.Validate(), "health check camel-k is registered by the server")
+}
@@ -34,3 +34,7 @@ func (cl TestClient) ListPipes(_ context.Context) (*camelv1.PipeList, error) {
 func (cl TestClient) Check(context.Context) error {
 	return nil
 }
+
+func (cl TestClient) Checks(client.ChecksOptions) []client.NamedCheck {
+	return nil
+}